@@ -1,82 +1,313 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/auth"
 	"github.com/DjMariarty/messenger/internal/config"
+	"github.com/DjMariarty/messenger/internal/health"
+	"github.com/DjMariarty/messenger/internal/logger"
+	"github.com/DjMariarty/messenger/internal/metrics"
 	"github.com/DjMariarty/messenger/internal/middleware"
-	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/migrations"
+	"github.com/DjMariarty/messenger/internal/realtime"
 	"github.com/DjMariarty/messenger/internal/repository"
 	"github.com/DjMariarty/messenger/internal/services"
+	"github.com/DjMariarty/messenger/internal/storage"
+	"github.com/DjMariarty/messenger/internal/tracing"
 	"github.com/DjMariarty/messenger/internal/transport"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
 func main() {
 	_ = godotenv.Load()
-	log := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: slog.LevelInfo,
-	}))
+	// Used until the configured logger exists.
+	log := slog.New(slog.NewTextHandler(os.Stderr, nil))
 
-	db := config.SetUpDatabaseConnection()
+	cfg, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Error("invalid config", slog.Any("error", err))
+		os.Exit(2)
+	}
+
+	logHandler, err := logger.NewHandler(os.Stdout, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		log.Error("invalid log config", slog.Any("error", err))
+		os.Exit(2)
+	}
+	log = slog.New(tracing.NewLogHandler(logHandler))
+	slog.SetDefault(log)
+
+	log.Info("config loaded", slog.Any("config", cfg))
+	auth.Configure(cfg.Auth.JWTSecret, cfg.Auth.AccessTTL, cfg.Auth.RefreshTTL)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		log.Error("tracing setup failed", slog.Any("error", err))
+		os.Exit(1)
+	}
 
-	if err := db.AutoMigrate(
-		&models.User{},
-		&models.Chat{},
-		&models.Message{},
-	); err != nil {
+	db, err := config.SetUpDatabaseConnection(cfg.Database)
+	if err != nil {
+		log.Error("database connection failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	sqlDB, err := db.DB()
+	if err != nil {
+		log.Error("database handle failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		log.Error("database metrics setup failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	if err := db.Use(tracing.GormPlugin{}); err != nil {
+		log.Error("database tracing setup failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	if err := metrics.RegisterDBStats(sqlDB, cfg.Database.Name); err != nil {
+		log.Error("database metrics setup failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+	migrator, err := migrations.New(sqlDB, log)
+	if err != nil {
+		log.Error("loading migrations failed", slog.Any("error", err))
+		os.Exit(1)
+	}
+
+	if len(args) > 0 && args[0] == "migrate" {
+		os.Exit(runMigrate(migrator, args[1:]))
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
 		log.Error("migrations failed", slog.Any("error", err))
 		os.Exit(1)
 	}
-	log.Info("migrations ok")
+	log.Info("migrations ok", slog.Int("applied", applied))
+
+	blobs, err := storage.New(context.Background(), cfg.Storage)
+	if err != nil {
+		log.Error("storage setup failed", slog.Any("error", err))
+		os.Exit(1)
+	}
 
 	userRepo := repository.NewUserRepository(db, log)
-	userService := services.NewUserService(db, userRepo, log)
-	userHandler := transport.NewUserHandler(userService, log)
+	tokenRepo := repository.NewTokenRepository(db, log)
+	tokenService := services.NewTokenService(db, tokenRepo, userRepo, log)
+	requireAuth := middleware.AuthRequired(tokenService)
+	queryAuth := middleware.QueryAuth(tokenService)
+	requestTimeout := middleware.Timeout(cfg.Server.RequestTimeout)
+
+	userService := services.NewUserService(db, userRepo, tokenService, log)
+	userHandler := transport.NewUserHandler(userService, tokenService, log)
+
+	hub := realtime.NewHub(log)
+	go hub.Run()
+	wsHandler := transport.NewWSHandler(hub, log)
 
 	chatRepo := repository.NewChatRepository(db)
-	chatService := services.NewChatService(db, chatRepo)
+	messageRepo := repository.NewMessageRepository(db, log, cfg.Search.Language)
+	chatAccess := services.NewChatAccess(chatRepo)
+
+	chatService := services.NewChatService(db, chatRepo, userRepo, messageRepo, chatAccess, hub)
 	chatHandler := transport.NewChatHandler(chatService)
 
-	messageRepo := repository.NewMessageRepository(db, log)
-	messageService := services.NewMessageService(messageRepo, log)
+	messageService := services.NewMessageService(messageRepo, chatAccess, hub, blobs, log)
 	messageHandler := transport.NewMessageHandler(messageService, log)
 
+	attachmentRepo := repository.NewAttachmentRepository(db, log)
+	thumbnailer := services.NewThumbnailer(attachmentRepo, blobs, cfg.Attachments.ThumbnailWorkers, log)
+	go thumbnailer.Run()
+	attachmentService := services.NewAttachmentService(attachmentRepo, chatAccess, blobs, thumbnailer, cfg.Attachments, log)
+	attachmentHandler := transport.NewAttachmentHandler(attachmentService, cfg.Attachments, log)
+
+	readiness := health.NewRegistry(2 * time.Second)
+	readiness.Register("database", sqlDB.PingContext)
+	readiness.Register("migrations", func(ctx context.Context) error {
+		pending, err := migrator.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if pending > 0 {
+			return fmt.Errorf("%d migration(s) pending", pending)
+		}
+		return nil
+	})
+	readiness.Register("storage", blobs.Ping)
+	readiness.Register("realtime_hub", func(context.Context) error {
+		if !hub.Running() {
+			return errors.New("hub is not running")
+		}
+		return nil
+	})
+	readiness.Register("thumbnailer", func(context.Context) error {
+		if !thumbnailer.Running() {
+			return errors.New("thumbnailer is not running")
+		}
+		return nil
+	})
+	healthHandler := transport.NewHealthHandler(readiness)
+
+	transport.UseJSONFieldNames()
 	router := gin.New()
+	router.Use(otelgin.Middleware(cfg.Tracing.ServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		// Probes and scrapes would drown out real traffic.
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			return false
+		}
+		return true
+	})))
+	// Inside the tracing span so access logs carry the trace id, and outside
+	// Recovery so that panics are logged as 500s.
+	router.Use(logger.Middleware(log))
 	router.Use(gin.Recovery())
-	router.Use(gin.Logger())
+	router.Use(metrics.HTTP())
+	// Innermost, so the metrics and access log see the status it renders.
+	router.Use(middleware.Errors())
+	router.NoRoute(func(c *gin.Context) {
+		_ = c.Error(apperr.ErrNotFound)
+	})
+
+	router.GET("/healthz", healthHandler.Live)
+	router.GET("/readyz", healthHandler.Ready)
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
 
 	auth := router.Group("/auth")
+	auth.Use(requestTimeout)
 	{
 		auth.POST("/register", userHandler.Register)
 		auth.POST("/login", userHandler.Login)
-		auth.GET("/me", middleware.AuthRequired(), userHandler.Me)
+		auth.POST("/refresh", userHandler.Refresh)
+		auth.POST("/logout", requireAuth, userHandler.Logout)
+		auth.GET("/me", requireAuth, userHandler.Me)
+		auth.PATCH("/me", requireAuth, userHandler.UpdateMe)
+
+		auth.GET("/sessions", requireAuth, userHandler.ListSessions)
+		auth.DELETE("/sessions", requireAuth, userHandler.RevokeOtherSessions)
+		auth.DELETE("/sessions/:id", requireAuth, userHandler.RevokeSession)
 	}
 
 	chats := router.Group("/chats")
-	chats.Use(middleware.AuthRequired())
+	chats.Use(requestTimeout, requireAuth)
 	{
 		chats.POST("", chatHandler.CreateChat)
 		chats.GET("", chatHandler.GetChats)
+		chats.GET("/:id/messages", messageHandler.ListChatMessages)
+		chats.POST("/:id/read", chatHandler.MarkRead)
+
+		chats.POST("/:id/members", chatHandler.AddMembers)
+		chats.DELETE("/:id/members/:user_id", chatHandler.RemoveMember)
+		chats.PATCH("/:id/members/:user_id", chatHandler.SetMemberRole)
+		chats.POST("/:id/leave", chatHandler.LeaveChat)
+		chats.POST("/:id/owner", chatHandler.TransferOwnership)
 	}
 
 	messages := router.Group("/messages")
-	messages.Use(middleware.AuthRequired())
+	messages.Use(requestTimeout, requireAuth)
 	{
 		messages.POST("", messageHandler.CreateMessage)
-		messages.GET("/:user_id", messageHandler.GetMessages)
+		messages.GET("/:id", messageHandler.ListChatMessagesLegacy)
+		messages.PATCH("/:id", messageHandler.EditMessage)
+		messages.DELETE("/:id", messageHandler.DeleteMessage)
+		messages.GET("/:id/edits", messageHandler.ListEdits)
+		messages.GET("/:id/thread", messageHandler.ListThread)
+		messages.POST("/:id/thread/read", messageHandler.MarkThreadRead)
 	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	search := router.Group("/search")
+	search.Use(requestTimeout, requireAuth)
+	{
+		search.GET("/messages", messageHandler.SearchMessages)
+	}
+
+	// Transfers take as long as the file needs, so these routes skip
+	// requestTimeout and the handler extends the connection deadlines.
+	attachments := router.Group("/attachments")
+	{
+		attachments.POST("", requireAuth, attachmentHandler.Upload)
+		attachments.GET("/:id", requestTimeout, requireAuth, attachmentHandler.Get)
+		attachments.GET("/:id/content", queryAuth, attachmentHandler.Content)
+		attachments.GET("/:id/thumbnails/:size", queryAuth, attachmentHandler.Thumbnail)
+	}
+
+	router.GET("/ws", queryAuth, wsHandler.Connect)
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Server.Port,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
 	}
 
-	log.Info("server starting", slog.String("port", port))
-	if err := router.Run(":" + port); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Info("server starting", slog.String("port", cfg.Server.Port))
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
 		log.Error("http server failed", slog.Any("error", err))
-		os.Exit(1)
+		exitCode = 1
+	case <-ctx.Done():
+		log.Info("shutdown signal received, draining",
+			slog.Duration("timeout", cfg.Server.ShutdownTimeout),
+		)
 	}
+	stop()
+
+	readiness.SetDraining()
+	if delay := cfg.Server.DrainDelay; delay > 0 && exitCode == 0 {
+		log.Info("readiness failing, waiting before closing listener", slog.Duration("delay", delay))
+		time.Sleep(delay)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	// Finish in-flight requests first: they may still publish events that the
+	// hub should flush before closing websockets.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error("http server shutdown incomplete", slog.Any("error", err))
+		exitCode = 1
+	}
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		log.Error("websocket shutdown incomplete", slog.Any("error", err))
+		exitCode = 1
+	}
+	if err := thumbnailer.Shutdown(shutdownCtx); err != nil {
+		log.Error("thumbnailer shutdown incomplete", slog.Any("error", err))
+		exitCode = 1
+	}
+	if err := sqlDB.Close(); err != nil {
+		log.Error("closing database failed", slog.Any("error", err))
+		exitCode = 1
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error("flushing traces failed", slog.Any("error", err))
+		exitCode = 1
+	}
+
+	log.Info("server stopped")
+	os.Exit(exitCode)
 }
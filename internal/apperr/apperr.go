@@ -0,0 +1,94 @@
+// Package apperr defines the errors the API reports to clients. Every error
+// carries a stable machine-readable code that clients can switch on, a
+// human-readable message and a kind that the HTTP layer maps to a status.
+// Anything that is not an *Error is treated as an internal failure and its
+// text never reaches the client.
+package apperr
+
+import "errors"
+
+type Kind uint8
+
+const (
+	Internal Kind = iota
+	Invalid
+	Unauthenticated
+	Forbidden
+	NotFound
+	Conflict
+	Timeout
+	Canceled
+	TooLarge
+	UnsupportedMediaType
+)
+
+type Error struct {
+	Kind    Kind
+	Code    string
+	Message string
+	// Details is rendered as is; validation errors put a ValidationDetails
+	// here.
+	Details any
+
+	cause error
+}
+
+func New(kind Kind, code, message string) *Error {
+	return &Error{Kind: kind, Code: code, Message: message}
+}
+
+// Generic errors for failures that have no more specific code.
+var (
+	ErrInternal        = New(Internal, "internal", "internal error")
+	ErrInvalidRequest  = New(Invalid, "invalid_request", "malformed request")
+	ErrEmptyBody       = New(Invalid, "empty_body", "request body is required")
+	ErrValidation      = New(Invalid, "validation_failed", "request validation failed")
+	ErrUnauthenticated = New(Unauthenticated, "unauthenticated", "authentication required")
+	ErrForbidden       = New(Forbidden, "forbidden", "forbidden")
+	ErrNotFound        = New(NotFound, "not_found", "not found")
+	ErrTimeout         = New(Timeout, "timeout", "request timed out")
+	ErrCanceled        = New(Canceled, "canceled", "request canceled")
+)
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.cause
+}
+
+// Is matches by code, so copies made by WithDetails or Wrap still satisfy
+// errors.Is against the sentinel they came from.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithDetails returns a copy of e carrying details.
+func (e *Error) WithDetails(details any) *Error {
+	cp := *e
+	cp.Details = details
+	return &cp
+}
+
+// Wrap returns a copy of e that records cause for logs. The cause is not
+// shown to clients.
+func (e *Error) Wrap(cause error) *Error {
+	cp := *e
+	cp.cause = cause
+	return &cp
+}
+
+// From returns the *Error in err's chain, or ErrInternal wrapping err when
+// there is none.
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
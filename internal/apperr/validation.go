@@ -0,0 +1,124 @@
+package apperr
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/DjMariarty/messenger/internal/i18n"
+	"github.com/go-playground/validator/v10"
+)
+
+// FieldError describes one offending request field. Message is the default
+// language text; Key and Args let the HTTP layer translate it.
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+
+	Key  string `json:"-"`
+	Args []any  `json:"-"`
+}
+
+// NewFieldError describes field failing rule, with the message looked up as
+// "validation.<rule>".
+func NewFieldError(field, rule string, args ...any) FieldError {
+	return fieldError(field, rule, "validation."+rule, args...)
+}
+
+func fieldError(field, rule, key string, args ...any) FieldError {
+	msg, _ := i18n.Translate(i18n.Default, key, args...)
+	return FieldError{Field: field, Rule: rule, Message: msg, Key: key, Args: args}
+}
+
+type ValidationDetails struct {
+	Fields []FieldError `json:"fields"`
+}
+
+// Validation reports the given fields as invalid.
+func Validation(fields ...FieldError) *Error {
+	return ErrValidation.WithDetails(ValidationDetails{Fields: fields})
+}
+
+// Field is shorthand for a single-field validation error.
+func Field(field, rule string, args ...any) *Error {
+	return Validation(NewFieldError(field, rule, args...))
+}
+
+// FromBinding converts an error returned by Gin's ShouldBind* into a
+// validation error listing the offending fields where they are known.
+func FromBinding(err error) *Error {
+	var verrs validator.ValidationErrors
+	if errors.As(err, &verrs) {
+		fields := make([]FieldError, 0, len(verrs))
+		for _, fe := range verrs {
+			fields = append(fields, ruleError(fe))
+		}
+		return Validation(fields...).Wrap(err)
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return Field(typeErr.Field, "type", jsonType(typeErr.Type)).Wrap(err)
+	}
+
+	if errors.Is(err, io.EOF) {
+		return ErrEmptyBody.Wrap(err)
+	}
+	return ErrInvalidRequest.Wrap(err)
+}
+
+// JSONFieldName reports struct fields by their json or form tag, so that
+// validation errors name fields the way clients send them. It is meant for
+// validator.Validate.RegisterTagNameFunc.
+func JSONFieldName(f reflect.StructField) string {
+	for _, tag := range []string{"json", "form"} {
+		name, _, _ := strings.Cut(f.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func ruleError(fe validator.FieldError) FieldError {
+	switch fe.Tag() {
+	case "required", "email":
+		return NewFieldError(fe.Field(), fe.Tag())
+	case "min", "max":
+		var unit string
+		switch fe.Kind() {
+		case reflect.String:
+			unit = "string"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			unit = "items"
+		default:
+			unit = "number"
+		}
+		return fieldError(fe.Field(), fe.Tag(), "validation."+fe.Tag()+"."+unit, fe.Param())
+	case "oneof":
+		return NewFieldError(fe.Field(), fe.Tag(), strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		return fieldError(fe.Field(), fe.Tag(), "validation.invalid")
+	}
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	default:
+		return "number"
+	}
+}
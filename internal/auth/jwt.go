@@ -1,50 +1,76 @@
 package auth
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"os"
-	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-var ErrInvalidToken = errors.New("недопустимый токен")
+var ErrInvalidToken = errors.New("invalid token")
 
 type Claims struct {
 	UserID uint `json:"user_id"`
+	// SessionID ties the access token to the login session it came from.
+	SessionID uint `json:"sid,omitempty"`
+	// Locale is the user's profile locale at the time the token was issued.
+	Locale string `json:"loc,omitempty"`
 	jwt.RegisteredClaims
 }
 
-func secret() []byte {
-	return []byte(os.Getenv("JWT_SECRET"))
+// RevocationList reports whether an otherwise valid token has been revoked
+// before its expiry, either by its id (jti) or through its session.
+type RevocationList interface {
+	IsRevoked(ctx context.Context, claims *Claims) (bool, error)
+}
+
+var (
+	signingKey []byte
+	accessTTL  = 15 * time.Minute
+	refreshTTL = 30 * 24 * time.Hour
+)
 
+// Configure sets the signing secret and token lifetimes. It must be called
+// once at startup, before any token is issued or parsed.
+func Configure(secret string, access, refresh time.Duration) {
+	signingKey = []byte(secret)
+	accessTTL = access
+	refreshTTL = refresh
 }
 
-func ttl() time.Duration {
-	v := os.Getenv("JWT_TTL_MINUTES")
-	if v == "" {
-		return 60 * time.Minute
-	}
-	n, err := strconv.Atoi(v)
-	if err != nil || n <= 0 {
-		return 60 * time.Minute
-	}
-	return time.Duration(n) * time.Minute
+func secret() []byte {
+	return signingKey
+}
+
+func RefreshTTL() time.Duration {
+	return refreshTTL
 }
 
-func GenerateToken(userID uint) (string, error) {
+// GenerateToken issues an access token and returns it with its claims.
+func GenerateToken(userID, sessionID uint, locale string) (string, *Claims, error) {
 	now := time.Now()
 
-	claims := Claims{
-		UserID: userID,
+	claims := &Claims{
+		UserID:    userID,
+		SessionID: sessionID,
+		Locale:    locale,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        NewID(),
 			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(ttl())),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTTL)),
 		},
 	}
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(secret())
+	signed, err := token.SignedString(secret())
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
 }
 
 func ParseToken(tokenStr string) (*Claims, error) {
@@ -63,3 +89,23 @@ func ParseToken(tokenStr string) (*Claims, error) {
 	}
 	return claims, nil
 }
+
+// NewID returns a random URL-safe identifier.
+func NewID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// NewRefreshToken returns an opaque refresh token and the hash to store.
+func NewRefreshToken() (token, hash string) {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashRefreshToken(token)
+}
+
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,603 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// minJWTSecretLength is the shortest HS256 secret we accept (256 bits).
+const minJWTSecretLength = 32
+
+type Config struct {
+	Server   ServerConfig
+	Database DatabaseConfig
+	Auth     AuthConfig
+	Tracing  TracingConfig
+	Log      LogConfig
+	Search   SearchConfig
+
+	Storage     StorageConfig
+	Attachments AttachmentConfig
+}
+
+// ServerConfig holds the HTTP listener settings.
+type ServerConfig struct {
+	Port              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	// RequestTimeout is the deadline for API requests, including the
+	// database work they trigger. It does not apply to websockets.
+	RequestTimeout time.Duration
+	// ShutdownTimeout bounds how long in-flight requests and websocket
+	// connections get to finish after SIGINT/SIGTERM.
+	ShutdownTimeout time.Duration
+	// DrainDelay is how long /readyz reports failing before the listener
+	// closes, giving load balancers time to stop routing new requests.
+	DrainDelay time.Duration
+}
+
+type DatabaseConfig struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+type AuthConfig struct {
+	JWTSecret  string
+	AccessTTL  time.Duration
+	RefreshTTL time.Duration
+}
+
+type LogConfig struct {
+	// Format is "text" or "json".
+	Format string
+	Level  string
+}
+
+type TracingConfig struct {
+	// Exporter is "otlp", "stdout" or "none". The OTLP endpoint and headers
+	// come from the standard OTEL_EXPORTER_OTLP_* variables.
+	Exporter    string
+	ServiceName string
+	SampleRatio float64
+}
+
+type SearchConfig struct {
+	// Language is the PostgreSQL text search configuration new messages are
+	// indexed with: "russian", "english" or "simple". It is stored per
+	// message, so changing it does not require reindexing old ones.
+	Language string
+}
+
+// StorageConfig selects where attachment blobs live.
+type StorageConfig struct {
+	// Driver is "local" or "s3".
+	Driver   string
+	LocalDir string
+
+	// S3 settings also fit S3-compatible servers such as MinIO; buckets are
+	// addressed path-style.
+	S3Endpoint  string
+	S3Bucket    string
+	S3Region    string
+	S3AccessKey string
+	S3SecretKey string
+	S3UseSSL    bool
+}
+
+type AttachmentConfig struct {
+	// MaxSize is the largest accepted upload in bytes.
+	MaxSize int64
+	// AllowedTypes lists accepted MIME types as sniffed from the content;
+	// "image/*" style wildcards match a whole family.
+	AllowedTypes []string
+	// TransferTimeout replaces the server read/write timeouts for uploads
+	// and downloads, which would cut large files short.
+	TransferTimeout time.Duration
+	// ThumbnailWorkers is how many images are thumbnailed in parallel.
+	ThumbnailWorkers int
+}
+
+func defaults() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port:              "8080",
+			ReadHeaderTimeout: 5 * time.Second,
+			ReadTimeout:       15 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       60 * time.Second,
+			RequestTimeout:    10 * time.Second,
+			ShutdownTimeout:   20 * time.Second,
+		},
+		Database: DatabaseConfig{
+			Host:            "localhost",
+			Port:            "5432",
+			User:            "postgres",
+			Name:            "messenger",
+			SSLMode:         "disable",
+			MaxOpenConns:    25,
+			MaxIdleConns:    5,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Auth: AuthConfig{
+			AccessTTL:  15 * time.Minute,
+			RefreshTTL: 30 * 24 * time.Hour,
+		},
+		Log: LogConfig{
+			Format: "text",
+			Level:  "info",
+		},
+		Tracing: TracingConfig{
+			Exporter:    "none",
+			ServiceName: "messenger",
+			SampleRatio: 1,
+		},
+		Search: SearchConfig{
+			Language: "russian",
+		},
+		Storage: StorageConfig{
+			Driver:   "local",
+			LocalDir: "data/attachments",
+			S3Region: "us-east-1",
+			S3UseSSL: true,
+		},
+		Attachments: AttachmentConfig{
+			MaxSize:          25 << 20,
+			AllowedTypes:     []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf", "text/plain", "audio/*", "video/mp4", "application/zip"},
+			TransferTimeout:  5 * time.Minute,
+			ThumbnailWorkers: 2,
+		},
+	}
+}
+
+// setting binds one field of Config to its file key, environment variable
+// and command-line flag.
+type setting struct {
+	key    string
+	env    string
+	flag   string
+	usage  string
+	secret bool
+	value  flag.Value
+}
+
+func (cfg *Config) settings() []setting {
+	return []setting{
+		{"server.port", "PORT", "port", "HTTP listen port", false, (*stringValue)(&cfg.Server.Port)},
+		{"server.read_header_timeout", "HTTP_READ_HEADER_TIMEOUT", "http-read-header-timeout", "time to read request headers", false, (*durationValue)(&cfg.Server.ReadHeaderTimeout)},
+		{"server.read_timeout", "HTTP_READ_TIMEOUT", "http-read-timeout", "time to read a whole request", false, (*durationValue)(&cfg.Server.ReadTimeout)},
+		{"server.write_timeout", "HTTP_WRITE_TIMEOUT", "http-write-timeout", "time to write a response", false, (*durationValue)(&cfg.Server.WriteTimeout)},
+		{"server.idle_timeout", "HTTP_IDLE_TIMEOUT", "http-idle-timeout", "keep-alive idle time", false, (*durationValue)(&cfg.Server.IdleTimeout)},
+		{"server.request_timeout", "HTTP_REQUEST_TIMEOUT", "http-request-timeout", "deadline for API requests", false, (*durationValue)(&cfg.Server.RequestTimeout)},
+		{"server.shutdown_timeout", "SHUTDOWN_TIMEOUT", "shutdown-timeout", "time to drain connections on shutdown", false, (*durationValue)(&cfg.Server.ShutdownTimeout)},
+		{"server.drain_delay", "SHUTDOWN_DRAIN_DELAY", "shutdown-drain-delay", "time to fail readiness before closing the listener", false, (*durationValue)(&cfg.Server.DrainDelay)},
+
+		{"database.host", "DB_HOST", "db-host", "PostgreSQL host", false, (*stringValue)(&cfg.Database.Host)},
+		{"database.port", "DB_PORT", "db-port", "PostgreSQL port", false, (*stringValue)(&cfg.Database.Port)},
+		{"database.user", "DB_USER", "db-user", "PostgreSQL user", false, (*stringValue)(&cfg.Database.User)},
+		{"database.password", "DB_PASSWORD", "db-password", "PostgreSQL password", true, (*stringValue)(&cfg.Database.Password)},
+		{"database.name", "DB_NAME", "db-name", "PostgreSQL database", false, (*stringValue)(&cfg.Database.Name)},
+		{"database.sslmode", "DB_SSLMODE", "db-sslmode", "PostgreSQL sslmode", false, (*stringValue)(&cfg.Database.SSLMode)},
+		{"database.max_open_conns", "DB_MAX_OPEN_CONNS", "db-max-open-conns", "maximum open connections", false, (*intValue)(&cfg.Database.MaxOpenConns)},
+		{"database.max_idle_conns", "DB_MAX_IDLE_CONNS", "db-max-idle-conns", "maximum idle connections", false, (*intValue)(&cfg.Database.MaxIdleConns)},
+		{"database.conn_max_lifetime", "DB_CONN_MAX_LIFETIME", "db-conn-max-lifetime", "maximum connection age, 0 for no limit", false, (*durationValue)(&cfg.Database.ConnMaxLifetime)},
+		{"database.conn_max_idle_time", "DB_CONN_MAX_IDLE_TIME", "db-conn-max-idle-time", "maximum connection idle time, 0 for no limit", false, (*durationValue)(&cfg.Database.ConnMaxIdleTime)},
+
+		{"auth.jwt_secret", "JWT_SECRET", "jwt-secret", "HS256 signing secret, at least 32 bytes", true, (*stringValue)(&cfg.Auth.JWTSecret)},
+		{"auth.access_ttl_minutes", "JWT_TTL_MINUTES", "access-ttl-minutes", "access token lifetime in minutes", false, &unitValue{&cfg.Auth.AccessTTL, time.Minute}},
+		{"auth.refresh_ttl_hours", "REFRESH_TTL_HOURS", "refresh-ttl-hours", "refresh token lifetime in hours", false, &unitValue{&cfg.Auth.RefreshTTL, time.Hour}},
+
+		{"log.format", "LOG_FORMAT", "log-format", "log output: text or json", false, (*stringValue)(&cfg.Log.Format)},
+		{"log.level", "LOG_LEVEL", "log-level", "minimum log level: debug, info, warn or error", false, (*stringValue)(&cfg.Log.Level)},
+
+		{"tracing.exporter", "OTEL_TRACES_EXPORTER", "tracing-exporter", "trace exporter: otlp, stdout or none", false, (*stringValue)(&cfg.Tracing.Exporter)},
+		{"tracing.service_name", "OTEL_SERVICE_NAME", "tracing-service-name", "service name reported in traces", false, (*stringValue)(&cfg.Tracing.ServiceName)},
+		{"tracing.sample_ratio", "OTEL_TRACES_SAMPLER_ARG", "tracing-sample-ratio", "fraction of new traces to sample, 0 to 1", false, (*floatValue)(&cfg.Tracing.SampleRatio)},
+
+		{"search.language", "SEARCH_LANGUAGE", "search-language", "text search language for new messages: russian, english or simple", false, (*stringValue)(&cfg.Search.Language)},
+
+		{"storage.driver", "STORAGE_DRIVER", "storage-driver", "attachment storage: local or s3", false, (*stringValue)(&cfg.Storage.Driver)},
+		{"storage.local_dir", "STORAGE_LOCAL_DIR", "storage-local-dir", "directory for local attachment storage", false, (*stringValue)(&cfg.Storage.LocalDir)},
+		{"storage.s3_endpoint", "S3_ENDPOINT", "s3-endpoint", "S3 endpoint host[:port]", false, (*stringValue)(&cfg.Storage.S3Endpoint)},
+		{"storage.s3_bucket", "S3_BUCKET", "s3-bucket", "S3 bucket for attachments", false, (*stringValue)(&cfg.Storage.S3Bucket)},
+		{"storage.s3_region", "S3_REGION", "s3-region", "S3 region", false, (*stringValue)(&cfg.Storage.S3Region)},
+		{"storage.s3_access_key", "S3_ACCESS_KEY", "s3-access-key", "S3 access key", true, (*stringValue)(&cfg.Storage.S3AccessKey)},
+		{"storage.s3_secret_key", "S3_SECRET_KEY", "s3-secret-key", "S3 secret key", true, (*stringValue)(&cfg.Storage.S3SecretKey)},
+		{"storage.s3_use_ssl", "S3_USE_SSL", "s3-use-ssl", "connect to S3 over HTTPS", false, (*boolValue)(&cfg.Storage.S3UseSSL)},
+
+		{"attachments.max_size", "ATTACHMENT_MAX_SIZE", "attachment-max-size", "largest upload, e.g. 25MB", false, (*sizeValue)(&cfg.Attachments.MaxSize)},
+		{"attachments.allowed_types", "ATTACHMENT_ALLOWED_TYPES", "attachment-allowed-types", "comma-separated MIME types, image/* style wildcards allowed", false, (*listValue)(&cfg.Attachments.AllowedTypes)},
+		{"attachments.transfer_timeout", "ATTACHMENT_TRANSFER_TIMEOUT", "attachment-transfer-timeout", "time allowed for one upload or download", false, (*durationValue)(&cfg.Attachments.TransferTimeout)},
+		{"attachments.thumbnail_workers", "ATTACHMENT_THUMBNAIL_WORKERS", "attachment-thumbnail-workers", "images thumbnailed in parallel", false, (*intValue)(&cfg.Attachments.ThumbnailWorkers)},
+	}
+}
+
+// Load builds the configuration from defaults, then the optional config file
+// (-config or CONFIG_FILE, YAML or TOML), then environment variables, then
+// flags, and validates the result. It returns the arguments left after the
+// flags, e.g. a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	cfg := defaults()
+	settings := cfg.settings()
+
+	fs := flag.NewFlagSet("messenger", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML or TOML config file")
+	fromFlags := make(map[string]string)
+	for _, s := range settings {
+		fs.Var(&deferredValue{key: s.key, values: fromFlags, display: s.value}, s.flag, s.usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *configFile != "" {
+		fromFile, err := readFile(*configFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		if err := apply(settings, fromFile); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", *configFile, err)
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			if err := s.value.Set(v); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	if err := apply(settings, fromFlags); err != nil {
+		return nil, nil, err
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, fs.Args(), nil
+}
+
+// apply sets every setting present in values, keyed by setting.key.
+func apply(settings []setting, values map[string]string) error {
+	known := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		known[s.key] = true
+		v, ok := values[s.key]
+		if !ok {
+			continue
+		}
+		if err := s.value.Set(v); err != nil {
+			return fmt.Errorf("%s: %w", s.key, err)
+		}
+	}
+	for key := range values {
+		if !known[key] {
+			return fmt.Errorf("unknown setting %q", key)
+		}
+	}
+	return nil
+}
+
+// readFile decodes a config file into flat "section.key" values.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var tree map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported format, use .yaml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	flat := make(map[string]string)
+	flatten("", tree, flat)
+	return flat, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]string) {
+	for k, v := range tree {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flatten(key, sub, out)
+			continue
+		}
+		if list, ok := v.([]any); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+			continue
+		}
+		out[key] = fmt.Sprint(v)
+	}
+}
+
+func (cfg *Config) validate() error {
+	var errs []error
+
+	if n := len(cfg.Auth.JWTSecret); n == 0 {
+		errs = append(errs, errors.New("JWT secret is not set"))
+	} else if n < minJWTSecretLength {
+		errs = append(errs, fmt.Errorf("JWT secret must be at least %d bytes, got %d", minJWTSecretLength, n))
+	}
+	if cfg.Auth.AccessTTL <= 0 || cfg.Auth.RefreshTTL <= 0 {
+		errs = append(errs, errors.New("token lifetimes must be positive"))
+	}
+
+	if port, err := strconv.Atoi(cfg.Server.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("server port %q is not a valid port", cfg.Server.Port))
+	}
+	for name, d := range map[string]time.Duration{
+		"read header timeout": cfg.Server.ReadHeaderTimeout,
+		"read timeout":        cfg.Server.ReadTimeout,
+		"write timeout":       cfg.Server.WriteTimeout,
+		"idle timeout":        cfg.Server.IdleTimeout,
+		"request timeout":     cfg.Server.RequestTimeout,
+		"shutdown timeout":    cfg.Server.ShutdownTimeout,
+	} {
+		if d <= 0 {
+			errs = append(errs, fmt.Errorf("server %s must be positive", name))
+		}
+	}
+
+	if cfg.Database.Host == "" || cfg.Database.Name == "" || cfg.Database.User == "" {
+		errs = append(errs, errors.New("database host, name and user are required"))
+	}
+	if cfg.Database.MaxOpenConns < 0 || cfg.Database.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database connection limits cannot be negative"))
+	}
+	if cfg.Database.MaxOpenConns > 0 && cfg.Database.MaxIdleConns > cfg.Database.MaxOpenConns {
+		errs = append(errs, errors.New("database max idle connections cannot exceed max open connections"))
+	}
+
+	switch cfg.Log.Format {
+	case "text", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log format %q must be text or json", cfg.Log.Format))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Log.Level)); err != nil {
+		errs = append(errs, fmt.Errorf("log level %q must be debug, info, warn or error", cfg.Log.Level))
+	}
+
+	switch cfg.Tracing.Exporter {
+	case "otlp", "stdout", "none":
+	default:
+		errs = append(errs, fmt.Errorf("tracing exporter %q must be otlp, stdout or none", cfg.Tracing.Exporter))
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		errs = append(errs, errors.New("tracing sample ratio must be between 0 and 1"))
+	}
+
+	switch cfg.Search.Language {
+	case "russian", "english", "simple":
+	default:
+		errs = append(errs, fmt.Errorf("search language %q must be russian, english or simple", cfg.Search.Language))
+	}
+
+	switch cfg.Storage.Driver {
+	case "local":
+		if cfg.Storage.LocalDir == "" {
+			errs = append(errs, errors.New("storage local dir is required for the local driver"))
+		}
+	case "s3":
+		if cfg.Storage.S3Endpoint == "" || cfg.Storage.S3Bucket == "" {
+			errs = append(errs, errors.New("S3 endpoint and bucket are required for the s3 driver"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage driver %q must be local or s3", cfg.Storage.Driver))
+	}
+	if cfg.Attachments.MaxSize <= 0 {
+		errs = append(errs, errors.New("attachment max size must be positive"))
+	}
+	if len(cfg.Attachments.AllowedTypes) == 0 {
+		errs = append(errs, errors.New("at least one attachment type must be allowed"))
+	}
+	if cfg.Attachments.TransferTimeout <= 0 {
+		errs = append(errs, errors.New("attachment transfer timeout must be positive"))
+	}
+	if cfg.Attachments.ThumbnailWorkers < 1 {
+		errs = append(errs, errors.New("at least one thumbnail worker is required"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// LogValue renders the effective configuration with secrets redacted.
+func (cfg *Config) LogValue() slog.Value {
+	settings := cfg.settings()
+	attrs := make([]slog.Attr, 0, len(settings))
+	for _, s := range settings {
+		v := s.value.String()
+		if s.secret && v != "" {
+			v = "[redacted]"
+		}
+		attrs = append(attrs, slog.String(s.key, v))
+	}
+	return slog.GroupValue(attrs...)
+}
+
+type stringValue string
+
+func (v *stringValue) Set(s string) error { *v = stringValue(s); return nil }
+func (v *stringValue) String() string     { return string(*v) }
+
+type intValue int
+
+func (v *intValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = intValue(n)
+	return nil
+}
+func (v *intValue) String() string { return strconv.Itoa(int(*v)) }
+
+type floatValue float64
+
+func (v *floatValue) Set(s string) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return fmt.Errorf("%q is not a number", s)
+	}
+	*v = floatValue(f)
+	return nil
+}
+func (v *floatValue) String() string { return strconv.FormatFloat(float64(*v), 'g', -1, 64) }
+
+type boolValue bool
+
+func (v *boolValue) Set(s string) error {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return fmt.Errorf("%q is not true or false", s)
+	}
+	*v = boolValue(b)
+	return nil
+}
+func (v *boolValue) String() string { return strconv.FormatBool(bool(*v)) }
+
+// sizeValue is a byte count with an optional KB, MB or GB suffix (powers of
+// 1024), e.g. "512KB" or "25MB".
+type sizeValue int64
+
+func (v *sizeValue) Set(s string) error {
+	num, mult := strings.ToUpper(strings.TrimSpace(s)), int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}, {"B", 1}} {
+		if n, ok := strings.CutSuffix(num, u.suffix); ok {
+			num, mult = strings.TrimSpace(n), u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("%q is not a size like 25MB", s)
+	}
+	*v = sizeValue(n * mult)
+	return nil
+}
+func (v *sizeValue) String() string {
+	n := int64(*v)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10}} {
+		if n >= u.mult && n%u.mult == 0 {
+			return strconv.FormatInt(n/u.mult, 10) + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// listValue is a comma-separated list. Lists in the config file are joined
+// the same way by flatten.
+type listValue []string
+
+func (v *listValue) Set(s string) error {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	*v = items
+	return nil
+}
+func (v *listValue) String() string { return strings.Join(*v, ",") }
+
+// durationValue accepts Go durations such as "15s" or "5m".
+type durationValue time.Duration
+
+func (v *durationValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return fmt.Errorf("%q is not a duration like 15s", s)
+	}
+	*v = durationValue(d)
+	return nil
+}
+func (v *durationValue) String() string { return time.Duration(*v).String() }
+
+// unitValue is a whole number of units, kept for the existing
+// JWT_TTL_MINUTES and REFRESH_TTL_HOURS variables.
+type unitValue struct {
+	dst  *time.Duration
+	unit time.Duration
+}
+
+func (v *unitValue) Set(s string) error {
+	n, err := strconv.Atoi(s)
+	if err != nil || n <= 0 {
+		return fmt.Errorf("%q is not a positive number", s)
+	}
+	*v.dst = time.Duration(n) * v.unit
+	return nil
+}
+func (v *unitValue) String() string {
+	if v.dst == nil {
+		return "0"
+	}
+	return strconv.FormatInt(int64(*v.dst/v.unit), 10)
+}
+
+// deferredValue records a flag so it can be applied after the config file
+// and environment, giving flags the highest precedence.
+type deferredValue struct {
+	key     string
+	values  map[string]string
+	display flag.Value
+}
+
+func (v *deferredValue) Set(s string) error {
+	if err := v.display.Set(s); err != nil {
+		return err
+	}
+	v.values[v.key] = s
+	return nil
+}
+
+func (v *deferredValue) String() string {
+	if v.display == nil {
+		return ""
+	}
+	return v.display.String()
+}
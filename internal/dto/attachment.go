@@ -0,0 +1,43 @@
+package dto
+
+import (
+	"mime/multipart"
+	"time"
+)
+
+type UploadAttachmentRequest struct {
+	ChatID uint                  `form:"chat_id" binding:"required"`
+	File   *multipart.FileHeader `form:"file" binding:"required"`
+}
+
+type AttachmentResponse struct {
+	ID        uint      `json:"id"`
+	ChatID    uint      `json:"chat_id"`
+	MessageID *uint     `json:"message_id,omitempty"`
+	FileName  string    `json:"file_name"`
+	MimeType  string    `json:"mime_type"`
+	Size      int64     `json:"size"`
+	Checksum  string    `json:"checksum"`
+	Width     *int      `json:"width,omitempty"`
+	Height    *int      `json:"height,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	// URL downloads the content; it needs the caller's access token, as a
+	// Bearer header or an access_token query parameter.
+	URL string `json:"url"`
+
+	// Thumbnails are JPEG or WebP previews of images, smallest first. They
+	// are made in the background; while ThumbnailsPending is set, fetch the
+	// attachment again later to get them.
+	ThumbnailsPending bool                `json:"thumbnails_pending,omitempty"`
+	Thumbnails        []ThumbnailResponse `json:"thumbnails,omitempty"`
+}
+
+type ThumbnailResponse struct {
+	// Size is the bound on the longer side; Width and Height are actual.
+	Size     int    `json:"size"`
+	MimeType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Bytes    int64  `json:"bytes"`
+	URL      string `json:"url"`
+}
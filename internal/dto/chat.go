@@ -2,18 +2,63 @@ package dto
 
 import "time"
 
+// CreateChatRequest creates a direct chat with PartnerID, or a group chat
+// when Type is "group" or MemberIDs is set. The caller is always a member.
 type CreateChatRequest struct {
-	PartnerID uint `json:"partner_id"`
+	PartnerID uint   `json:"partner_id"`
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	MemberIDs []uint `json:"member_ids"`
 }
 
 type CreateChatResponse struct {
-	ChatID  uint `json:"chat_id"`
-	User1ID uint `json:"user1_id"`
-	User2ID uint `json:"user2_id"`
+	ChatID    uint   `json:"chat_id"`
+	Type      string `json:"type"`
+	Title     string `json:"title,omitempty"`
+	User1ID   uint   `json:"user1_id,omitempty"`
+	User2ID   uint   `json:"user2_id,omitempty"`
+	MemberIDs []uint `json:"member_ids"`
 }
 
 type ChatResponse struct {
 	ChatID          uint       `json:"chat_id"`
+	Type            string     `json:"type"`
+	Title           string     `json:"title,omitempty"`
 	LastMessage     string     `json:"last_message"`
 	LastMessageTime *time.Time `json:"last_message_time"`
+	LastEdited      bool       `json:"last_message_edited"`
+	LastDeleted     bool       `json:"last_message_deleted"`
+	UnreadCount     int64      `json:"unread_count"`
+}
+
+type AddMembersRequest struct {
+	UserIDs []uint `json:"user_ids" binding:"required,min=1"`
+}
+
+type SetMemberRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type TransferOwnershipRequest struct {
+	UserID uint `json:"user_id" binding:"required"`
+}
+
+type ChatListQuery struct {
+	Before string `form:"before"`
+	Limit  int    `form:"limit"`
+}
+
+type ChatPage struct {
+	Chats      []ChatResponse `json:"chats"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type MarkReadRequest struct {
+	MessageID uint `json:"message_id"`
+}
+
+type ReadReceipt struct {
+	ChatID            uint `json:"chat_id"`
+	UserID            uint `json:"user_id"`
+	LastReadMessageID uint `json:"last_read_message_id"`
 }
@@ -1,7 +1,120 @@
 package dto
 
+import "time"
+
 type CreateMessageRequest struct {
 	ChatID   uint   `json:"chat_id"`
-	SenderID uint   `json:"sender_id"`
+	SenderID uint   `json:"sender_id,omitempty"`
+	Text     string `json:"text"`
+	// AttachmentIDs are uploads of the sender into the same chat that have
+	// not been sent yet. With attachments the text may be empty.
+	AttachmentIDs []uint `json:"attachment_ids"`
+	// ReplyToMessageID quotes a message of the same chat.
+	ReplyToMessageID *uint `json:"reply_to_message_id"`
+	// ThreadRootID posts the message into the thread of a top-level message
+	// of a group chat instead of the main history.
+	ThreadRootID *uint `json:"thread_root_id"`
+}
+
+type MessageResponse struct {
+	ID        uint       `json:"id"`
+	ChatID    uint       `json:"chat_id"`
+	SenderID  uint       `json:"sender_id"`
+	Type      string     `json:"type"`
+	Text      string     `json:"text"`
+	CreatedAt time.Time  `json:"created_at"`
+	EditedAt  *time.Time `json:"edited_at,omitempty"`
+	Deleted   bool       `json:"deleted,omitempty"`
+
+	Attachments []AttachmentResponse `json:"attachments,omitempty"`
+	ReplyTo     *MessagePreview      `json:"reply_to,omitempty"`
+
+	ThreadRootID *uint `json:"thread_root_id,omitempty"`
+	// Thread summarizes the replies to a root message; it is absent while
+	// there are none.
+	Thread *ThreadSummary `json:"thread,omitempty"`
+	// Read is only set on the caller's own messages in direct chats.
+	Read *bool `json:"read,omitempty"`
+}
+
+// MessagePreview is the compact form of a quoted message. Text is cut to an
+// excerpt and is empty once the message is deleted.
+type MessagePreview struct {
+	ID       uint   `json:"id"`
+	SenderID uint   `json:"sender_id,omitempty"`
+	Type     string `json:"type,omitempty"`
 	Text     string `json:"text"`
+	Deleted  bool   `json:"deleted,omitempty"`
+}
+
+type ThreadSummary struct {
+	ReplyCount  int       `json:"reply_count"`
+	LastReplyAt time.Time `json:"last_reply_at"`
+	// UnreadCount is only filled in history and thread pages, which are
+	// fetched by one user; pushed events leave it out.
+	UnreadCount *int `json:"unread_count,omitempty"`
+}
+
+// ThreadPage is one page of a thread's replies, in the same order and with
+// the same cursors as MessagePage, together with its root message.
+type ThreadPage struct {
+	Root       MessageResponse   `json:"root"`
+	Messages   []MessageResponse `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+type ThreadReadReceipt struct {
+	RootMessageID     uint `json:"root_message_id"`
+	UserID            uint `json:"user_id"`
+	LastReadMessageID uint `json:"last_read_message_id"`
+}
+
+type EditMessageRequest struct {
+	Text string `json:"text" binding:"required"`
+}
+
+type DeleteMessageQuery struct {
+	Scope string `form:"scope"`
+}
+
+type MessageEditResponse struct {
+	Text       string    `json:"text"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type MessageHistoryQuery struct {
+	Before string `form:"before"`
+	After  string `form:"after"`
+	Limit  int    `form:"limit"`
+}
+
+type MessagePage struct {
+	Messages   []MessageResponse `json:"messages"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+// MessageSearchQuery searches the caller's chats. From and Before bound the
+// time messages were sent in, [from, before), as RFC 3339 times; SenderID
+// narrows results to one sender. Cursor is the next_cursor of the previous
+// page.
+type MessageSearchQuery struct {
+	Q        string    `form:"q" binding:"required,max=256"`
+	ChatID   uint      `form:"chat_id"`
+	SenderID uint      `form:"sender_id"`
+	From     time.Time `form:"from" time_format:"2006-01-02T15:04:05Z07:00"`
+	Before   time.Time `form:"before" time_format:"2006-01-02T15:04:05Z07:00"`
+	Cursor   string    `form:"cursor"`
+	Limit    int       `form:"limit"`
+}
+
+type MessageSearchResult struct {
+	Message MessageResponse `json:"message"`
+	// Snippet is HTML-escaped text with matches wrapped in <mark></mark>.
+	Snippet string  `json:"snippet"`
+	Rank    float32 `json:"rank"`
+}
+
+type MessageSearchPage struct {
+	Results    []MessageSearchResult `json:"results"`
+	NextCursor string                `json:"next_cursor,omitempty"`
 }
@@ -0,0 +1,105 @@
+// Package health runs the dependency checks behind the liveness and
+// readiness endpoints.
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	StatusOK           = "ok"
+	StatusFailing      = "failing"
+	StatusShuttingDown = "shutting_down"
+)
+
+// Check reports whether one dependency is usable.
+type Check func(ctx context.Context) error
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Healthy reports whether the service should receive traffic.
+func (r Report) Healthy() bool {
+	return r.Status == StatusOK
+}
+
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Registry holds the readiness checks. Checks are registered at startup and
+// run concurrently on every probe, each bounded by the registry timeout.
+type Registry struct {
+	timeout  time.Duration
+	checks   []namedCheck
+	draining atomic.Bool
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout}
+}
+
+// Register adds a readiness check. It is not safe to call once probes are
+// being served.
+func (r *Registry) Register(name string, check Check) {
+	r.checks = append(r.checks, namedCheck{name: name, check: check})
+}
+
+// SetDraining makes readiness fail from now on, so the orchestrator stops
+// routing traffic while in-flight requests finish.
+func (r *Registry) SetDraining() {
+	r.draining.Store(true)
+}
+
+func (r *Registry) Live() Report {
+	return Report{Status: StatusOK}
+}
+
+func (r *Registry) Ready(ctx context.Context) Report {
+	if r.draining.Load() {
+		return Report{Status: StatusShuttingDown}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(r.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, nc := range r.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := nc.check(ctx)
+
+			res := CheckResult{Status: StatusOK, DurationMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				res.Status = StatusFailing
+				res.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[nc.name] = res
+			if err != nil {
+				report.Status = StatusFailing
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
@@ -0,0 +1,91 @@
+package i18n
+
+var en = map[string]string{
+	// Generic
+	"internal":          "internal error",
+	"invalid_request":   "malformed request",
+	"empty_body":        "request body is required",
+	"validation_failed": "request validation failed",
+	"unauthenticated":   "authentication required",
+	"forbidden":         "forbidden",
+	"not_found":         "not found",
+	"timeout":           "request timed out",
+	"canceled":          "request canceled",
+
+	// Authentication
+	"missing_token":                "missing token",
+	"invalid_authorization_header": "invalid authorization header",
+	"invalid_token":                "invalid token",
+	"token_revoked":                "token revoked",
+	"invalid_refresh_token":        "invalid refresh token",
+	"refresh_token_reused":         "refresh token reuse detected",
+	"session_not_found":            "session not found",
+	"session_required":             "sign in again to manage your sessions",
+
+	// Users
+	"user_not_found":      "user not found",
+	"invalid_credentials": "invalid email or password",
+	"email_taken":         "a user with this email already exists",
+	"unsupported_locale":  "locale is not supported",
+
+	// Chats
+	"chat_not_found":       "chat not found",
+	"not_chat_member":      "user is not a member of this chat",
+	"sender_mismatch":      "sender_id does not match the authenticated user",
+	"invalid_chat_type":    "chat type must be direct or group",
+	"group_title_required": "group chat title is required",
+	"title_too_long":       "chat title is too long",
+	"group_too_small":      "group chat needs at least one other member",
+	"group_too_large":      "group chat has too many members",
+	"chat_with_self":       "cannot create chat with yourself",
+	"unknown_members":      "some members do not exist",
+	"invalid_cursor":       "invalid cursor",
+
+	// Members
+	"not_group_chat":      "members can only be managed in group chats",
+	"insufficient_role":   "not enough rights for this action",
+	"member_not_found":    "user is not a member of this chat",
+	"already_member":      "user is already a member of this chat",
+	"invalid_role":        "role must be admin or member",
+	"cannot_target_self":  "this action cannot be applied to yourself",
+	"owner_must_transfer": "owner must transfer ownership before leaving",
+	"no_users_to_add":     "no users to add",
+	"invalid_user_id":     "invalid user id",
+
+	// Messages
+	"invalid_chat_id":      "chat_id cannot be 0",
+	"invalid_sender_id":    "sender_id cannot be 0",
+	"empty_message":        "text cannot be empty",
+	"conflicting_page":     "before and after cannot be used together",
+	"message_not_found":    "message not found",
+	"not_message_sender":   "only the sender can change this message",
+	"message_not_editable": "message cannot be changed",
+	"invalid_delete_scope": "scope must be me or everyone",
+	"invalid_reply_target": "reply must quote a message in the same chat",
+	"invalid_thread_root":  "threads start at a top-level message of the same chat",
+	"threads_group_only":   "threads are only available in group chats",
+
+	// Attachments
+	"attachment_not_found":        "attachment not found",
+	"attachment_too_large":        "attachment is too large",
+	"attachment_type_not_allowed": "attachment type is not allowed",
+	"empty_attachment":            "attachment is empty",
+	"invalid_image":               "image file is damaged",
+	"thumbnail_not_found":         "thumbnail not found",
+	"attachments_unavailable":     "attachments must be your unsent uploads to this chat",
+	"too_many_attachments":        "too many attachments",
+
+	// Field validation
+	"validation.required":   "is required",
+	"validation.email":      "must be a valid email address",
+	"validation.min.string": "must be at least %s characters long",
+	"validation.min.items":  "must contain at least %s items",
+	"validation.min.number": "must be at least %s",
+	"validation.max.string": "must be at most %s characters long",
+	"validation.max.items":  "must contain at most %s items",
+	"validation.max.number": "must be at most %s",
+	"validation.oneof":      "must be one of: %s",
+	"validation.type":       "must be of type %s",
+	"validation.id":         "must be a positive integer",
+	"validation.invalid":    "is invalid",
+}
@@ -0,0 +1,69 @@
+// Package i18n holds the translations of client-facing API text and picks
+// the language to answer in.
+//
+// Messages are looked up by key: apperr codes for errors and
+// "validation.<rule>" for field validation messages. Code compares errors by
+// identity and never by text, so translations can change freely.
+package i18n
+
+import (
+	"fmt"
+
+	"golang.org/x/text/language"
+)
+
+const (
+	English = "en"
+	Russian = "ru"
+
+	Default = English
+)
+
+var catalogs = map[string]map[string]string{
+	English: en,
+	Russian: ru,
+}
+
+// matcher lists the supported languages, the default first: it is what the
+// matcher falls back to.
+var matcher = language.NewMatcher([]language.Tag{language.English, language.Russian})
+
+// Supported reports whether lang has a catalog.
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Negotiate picks the response language. A locale stored in the user's
+// profile wins; otherwise the best match for the Accept-Language header is
+// used, and Default when nothing matches.
+func Negotiate(profile, acceptLanguage string) string {
+	if Supported(profile) {
+		return profile
+	}
+	if acceptLanguage == "" {
+		return Default
+	}
+	tag, _ := language.MatchStrings(matcher, acceptLanguage)
+	base, _ := tag.Base()
+	if Supported(base.String()) {
+		return base.String()
+	}
+	return Default
+}
+
+// Translate formats the message for key in lang, falling back to Default.
+// It reports false when neither catalog knows the key.
+func Translate(lang, key string, args ...any) (string, bool) {
+	msg, ok := catalogs[lang][key]
+	if !ok {
+		msg, ok = catalogs[Default][key]
+	}
+	if !ok {
+		return "", false
+	}
+	if len(args) > 0 {
+		msg = fmt.Sprintf(msg, args...)
+	}
+	return msg, true
+}
@@ -0,0 +1,91 @@
+package i18n
+
+var ru = map[string]string{
+	// Generic
+	"internal":          "внутренняя ошибка сервера",
+	"invalid_request":   "некорректный запрос",
+	"empty_body":        "тело запроса обязательно",
+	"validation_failed": "запрос не прошёл проверку",
+	"unauthenticated":   "требуется авторизация",
+	"forbidden":         "доступ запрещён",
+	"not_found":         "не найдено",
+	"timeout":           "превышено время ожидания запроса",
+	"canceled":          "запрос отменён",
+
+	// Authentication
+	"missing_token":                "отсутствует токен",
+	"invalid_authorization_header": "некорректный заголовок Authorization",
+	"invalid_token":                "недопустимый токен",
+	"token_revoked":                "токен отозван",
+	"invalid_refresh_token":        "недопустимый refresh-токен",
+	"refresh_token_reused":         "обнаружено повторное использование refresh-токена",
+	"session_not_found":            "сессия не найдена",
+	"session_required":             "войдите заново, чтобы управлять сессиями",
+
+	// Users
+	"user_not_found":      "пользователь не найден",
+	"invalid_credentials": "неверный email или пароль",
+	"email_taken":         "пользователь с таким email уже существует",
+	"unsupported_locale":  "язык не поддерживается",
+
+	// Chats
+	"chat_not_found":       "чат не найден",
+	"not_chat_member":      "пользователь не состоит в этом чате",
+	"sender_mismatch":      "sender_id не совпадает с авторизованным пользователем",
+	"invalid_chat_type":    "тип чата должен быть direct или group",
+	"group_title_required": "у группового чата должно быть название",
+	"title_too_long":       "название чата слишком длинное",
+	"group_too_small":      "в групповом чате должен быть хотя бы ещё один участник",
+	"group_too_large":      "в групповом чате слишком много участников",
+	"chat_with_self":       "нельзя создать чат с самим собой",
+	"unknown_members":      "некоторые участники не существуют",
+	"invalid_cursor":       "недопустимый курсор",
+
+	// Members
+	"not_group_chat":      "участниками можно управлять только в групповых чатах",
+	"insufficient_role":   "недостаточно прав для этого действия",
+	"member_not_found":    "пользователь не состоит в этом чате",
+	"already_member":      "пользователь уже состоит в этом чате",
+	"invalid_role":        "роль должна быть admin или member",
+	"cannot_target_self":  "это действие нельзя применить к себе",
+	"owner_must_transfer": "перед выходом владелец должен передать права",
+	"no_users_to_add":     "нет пользователей для добавления",
+	"invalid_user_id":     "недопустимый id пользователя",
+
+	// Messages
+	"invalid_chat_id":      "chat_id не может быть 0",
+	"invalid_sender_id":    "sender_id не может быть 0",
+	"empty_message":        "текст не может быть пустым",
+	"conflicting_page":     "before и after нельзя использовать вместе",
+	"message_not_found":    "сообщение не найдено",
+	"not_message_sender":   "изменить сообщение может только отправитель",
+	"message_not_editable": "сообщение нельзя изменить",
+	"invalid_delete_scope": "scope должен быть me или everyone",
+	"invalid_reply_target": "ответить можно только на сообщение из этого же чата",
+	"invalid_thread_root":  "тред начинается с сообщения верхнего уровня из этого же чата",
+	"threads_group_only":   "треды доступны только в групповых чатах",
+
+	// Attachments
+	"attachment_not_found":        "вложение не найдено",
+	"attachment_too_large":        "вложение слишком большое",
+	"attachment_type_not_allowed": "такой тип вложения не разрешён",
+	"empty_attachment":            "вложение пустое",
+	"invalid_image":               "файл изображения повреждён",
+	"thumbnail_not_found":         "миниатюра не найдена",
+	"attachments_unavailable":     "вложения должны быть вашими неотправленными загрузками в этот чат",
+	"too_many_attachments":        "слишком много вложений",
+
+	// Field validation
+	"validation.required":   "обязательное поле",
+	"validation.email":      "должен быть корректным email-адресом",
+	"validation.min.string": "должно быть не короче %s символов",
+	"validation.min.items":  "должно содержать не меньше %s элементов",
+	"validation.min.number": "должно быть не меньше %s",
+	"validation.max.string": "должно быть не длиннее %s символов",
+	"validation.max.items":  "должно содержать не больше %s элементов",
+	"validation.max.number": "должно быть не больше %s",
+	"validation.oneof":      "должно быть одним из: %s",
+	"validation.type":       "должно иметь тип %s",
+	"validation.id":         "должно быть положительным целым числом",
+	"validation.invalid":    "недопустимое значение",
+}
@@ -0,0 +1,66 @@
+package media
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// Layout of the TIFF structure made by testExif.
+const (
+	exifIFD0      = 8
+	exifGPSIFD    = exifIFD0 + 2 + 2*12 + 4
+	exifLatitude  = exifGPSIFD + 2 + 2*12 + 4
+	exifTIFFBytes = exifLatitude + 3*8
+)
+
+// testExif returns a TIFF structure whose IFD0 holds orientation and a
+// pointer to a GPS directory. The GPS directory has the latitude reference
+// inline and the latitude itself stored after it.
+func testExif(order binary.ByteOrder, orientation uint16) []byte {
+	tiff := make([]byte, exifTIFFBytes)
+	if order == binary.ByteOrder(binary.LittleEndian) {
+		copy(tiff, "II")
+	} else {
+		copy(tiff, "MM")
+	}
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], exifIFD0)
+
+	entry := func(at int, tag, typ uint16, count, value uint32) {
+		order.PutUint16(tiff[at:], tag)
+		order.PutUint16(tiff[at+2:], typ)
+		order.PutUint32(tiff[at+4:], count)
+		order.PutUint32(tiff[at+8:], value)
+	}
+
+	order.PutUint16(tiff[exifIFD0:], 2)
+	entry(exifIFD0+2, tagOrientation, 3, 1, 0)
+	order.PutUint16(tiff[exifIFD0+2+8:], orientation)
+	entry(exifIFD0+2+12, tagGPSIFD, 4, 1, exifGPSIFD)
+
+	order.PutUint16(tiff[exifGPSIFD:], 2)
+	entry(exifGPSIFD+2, 0x0001, 2, 2, 0) // GPSLatitudeRef
+	copy(tiff[exifGPSIFD+2+8:], "N\x00")
+	entry(exifGPSIFD+2+12, 0x0002, 5, 3, exifLatitude) // GPSLatitude
+	for i, v := range []uint32{55, 1, 45, 1, 1234, 100} {
+		order.PutUint32(tiff[exifLatitude+4*i:], v)
+	}
+	return tiff
+}
+
+// checkGPSCleared fails unless the GPS directory of a testExif structure,
+// with the values it points to, is gone and IFD0 is intact.
+func checkGPSCleared(t *testing.T, tiff []byte, order binary.ByteOrder, orientation uint16) {
+	t.Helper()
+	if len(tiff) != exifTIFFBytes {
+		t.Fatalf("EXIF is %d bytes, want %d", len(tiff), exifTIFFBytes)
+	}
+	for i, b := range tiff[exifGPSIFD:] {
+		if b != 0 {
+			t.Fatalf("GPS data left at offset %d", exifGPSIFD+i)
+		}
+	}
+	if got := order.Uint16(tiff[exifIFD0+2+8:]); got != orientation {
+		t.Fatalf("orientation = %d, want %d", got, orientation)
+	}
+}
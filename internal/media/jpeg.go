@@ -0,0 +1,202 @@
+// Package media processes uploaded images: it removes location metadata from
+// JPEG, PNG and WebP files and renders thumbnails.
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrInvalidJPEG = errors.New("media: invalid JPEG")
+
+// maxJPEGHeader bounds how much metadata is buffered before the image data.
+const maxJPEGHeader = 4 << 20
+
+const (
+	markerSOI  = 0xD8
+	markerEOI  = 0xD9
+	markerSOS  = 0xDA
+	markerAPP1 = 0xE1
+)
+
+var (
+	exifPrefix        = []byte("Exif\x00\x00")
+	xmpPrefix         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedPrefix = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// JPEGHeader is the metadata part of a JPEG stream, everything up to the
+// start of the image data, with location data removed.
+type JPEGHeader struct {
+	// Bytes replaces the first Consumed bytes of the original stream.
+	Bytes    []byte
+	Consumed int64
+	// Orientation is the EXIF orientation, 1 to 8; 1 when there is none.
+	Orientation int
+}
+
+// ReadJPEGHeader reads r up to the image data and returns a cleaned copy of
+// the header: the GPS directory of EXIF metadata is emptied and XMP packets,
+// which can repeat the location, are dropped. Other metadata, orientation
+// included, is kept. The rest of the image is left unread in r, so
+// io.MultiReader(bytes.NewReader(h.Bytes), r) yields the cleaned file.
+func ReadJPEGHeader(r io.Reader) (*JPEGHeader, error) {
+	h := &JPEGHeader{Orientation: 1}
+	var out bytes.Buffer
+
+	var soi [2]byte
+	if _, err := io.ReadFull(r, soi[:]); err != nil || soi[0] != 0xFF || soi[1] != markerSOI {
+		return nil, ErrInvalidJPEG
+	}
+	out.Write(soi[:])
+	h.Consumed = 2
+
+	var b [1]byte
+	for {
+		// A marker is 0xFF followed by its code, optionally after fill bytes.
+		if _, err := io.ReadFull(r, b[:]); err != nil || b[0] != 0xFF {
+			return nil, ErrInvalidJPEG
+		}
+		h.Consumed++
+		for b[0] == 0xFF {
+			if _, err := io.ReadFull(r, b[:]); err != nil {
+				return nil, ErrInvalidJPEG
+			}
+			h.Consumed++
+		}
+		marker := b[0]
+
+		switch {
+		case marker == markerSOS:
+			out.Write([]byte{0xFF, marker})
+			h.Bytes = out.Bytes()
+			return h, nil
+		case marker == markerEOI || marker == markerSOI:
+			return nil, ErrInvalidJPEG
+		case marker == 0x01 || marker >= 0xD0 && marker <= 0xD7:
+			// Standalone markers carry no length.
+			out.Write([]byte{0xFF, marker})
+			continue
+		}
+
+		var length [2]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return nil, ErrInvalidJPEG
+		}
+		n := int(binary.BigEndian.Uint16(length[:]))
+		if n < 2 || h.Consumed+int64(n) > maxJPEGHeader {
+			return nil, ErrInvalidJPEG
+		}
+		segment := make([]byte, n-2)
+		if _, err := io.ReadFull(r, segment); err != nil {
+			return nil, ErrInvalidJPEG
+		}
+		h.Consumed += int64(n)
+
+		if marker == markerAPP1 {
+			switch {
+			case bytes.HasPrefix(segment, xmpPrefix), bytes.HasPrefix(segment, xmpExtendedPrefix):
+				continue
+			case bytes.HasPrefix(segment, exifPrefix):
+				if o := scrubExif(segment[len(exifPrefix):]); o >= 1 && o <= 8 {
+					h.Orientation = o
+				}
+			}
+		}
+		out.Write([]byte{0xFF, marker})
+		out.Write(length[:])
+		out.Write(segment)
+	}
+}
+
+const (
+	tagOrientation = 0x0112
+	tagGPSIFD      = 0x8825
+)
+
+// scrubExif empties the GPS directory of a TIFF structure in place and
+// returns the orientation found in IFD0, or 0. Malformed data is left as is.
+func scrubExif(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	entries, ok := ifdEntries(tiff, order, order.Uint32(tiff[4:8]))
+	if !ok {
+		return 0
+	}
+	orientation := 0
+	for _, e := range entries {
+		switch order.Uint16(e[0:2]) {
+		case tagOrientation:
+			orientation = int(order.Uint16(e[8:10]))
+		case tagGPSIFD:
+			clearIFD(tiff, order, order.Uint32(e[8:12]))
+		}
+	}
+	return orientation
+}
+
+// ifdEntries returns the 12-byte entries of the directory at offset.
+func ifdEntries(tiff []byte, order binary.ByteOrder, offset uint32) ([][]byte, bool) {
+	if uint64(offset)+2 > uint64(len(tiff)) {
+		return nil, false
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12 > len(tiff) {
+		return nil, false
+	}
+	entries := make([][]byte, count)
+	for i := range entries {
+		entries[i] = tiff[start+i*12 : start+(i+1)*12]
+	}
+	return entries, true
+}
+
+// clearIFD zeroes every value of the directory at offset, including values
+// stored outside it, and then marks the directory as empty.
+func clearIFD(tiff []byte, order binary.ByteOrder, offset uint32) {
+	entries, ok := ifdEntries(tiff, order, offset)
+	if !ok {
+		return
+	}
+	for _, e := range entries {
+		size := uint64(typeSize(order.Uint16(e[2:4]))) * uint64(order.Uint32(e[4:8]))
+		if size > 4 {
+			at := uint64(order.Uint32(e[8:12]))
+			if at+size <= uint64(len(tiff)) {
+				clear(tiff[at : at+size])
+			}
+		}
+		clear(e)
+	}
+	order.PutUint16(tiff[offset:], 0)
+}
+
+// typeSize is the size in bytes of one value of a TIFF field type.
+func typeSize(t uint16) int {
+	switch t {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	default:
+		return 0
+	}
+}
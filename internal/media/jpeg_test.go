@@ -0,0 +1,219 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+// testJPEG encodes a small image and inserts the given segments after SOI.
+func testJPEG(t testing.TB, segments ...[]byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	file := append([]byte{}, encoded[:2]...)
+	for _, s := range segments {
+		file = append(file, s...)
+	}
+	return append(file, encoded[2:]...)
+}
+
+func testAPP1(prefix []byte, data []byte) []byte {
+	segment := []byte{0xFF, markerAPP1}
+	segment = binary.BigEndian.AppendUint16(segment, uint16(2+len(prefix)+len(data)))
+	segment = append(segment, prefix...)
+	return append(segment, data...)
+}
+
+// readJPEG cleans file and checks that the result is the same file with
+// the header replaced.
+func readJPEG(t *testing.T, file []byte) (*JPEGHeader, []byte) {
+	t.Helper()
+	r := bytes.NewReader(file)
+	h, err := ReadJPEGHeader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rest, file[h.Consumed:]) {
+		t.Fatalf("Consumed = %d, but %d bytes are left", h.Consumed, len(rest))
+	}
+	out := append(append([]byte{}, h.Bytes...), rest...)
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("cleaned file does not decode: %v", err)
+	}
+	return h, out
+}
+
+// jpegAPP1 returns the APP1 segments of a cleaned header.
+func jpegAPP1(header []byte) [][]byte {
+	var segments [][]byte
+	for rest := header[2:]; len(rest) >= 4 && rest[1] != markerSOS; {
+		n := int(binary.BigEndian.Uint16(rest[2:]))
+		if rest[1] == markerAPP1 {
+			segments = append(segments, rest[4:2+n])
+		}
+		rest = rest[2+n:]
+	}
+	return segments
+}
+
+func TestReadJPEGHeader(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			file := testJPEG(t,
+				testAPP1(exifPrefix, testExif(order, 6)),
+				testAPP1(xmpPrefix, []byte(`<x:xmpmeta><exif:GPSLatitude>55,45.2N</exif:GPSLatitude></x:xmpmeta>`)),
+				testAPP1(xmpExtendedPrefix, []byte("0123456789abcdef0123456789abcdef\x00\x00\x00\x10\x00\x00\x00\x00more xmp")),
+			)
+
+			h, _ := readJPEG(t, file)
+			if h.Orientation != 6 {
+				t.Fatalf("Orientation = %d, want 6", h.Orientation)
+			}
+			app1 := jpegAPP1(h.Bytes)
+			if len(app1) != 1 || !bytes.HasPrefix(app1[0], exifPrefix) {
+				t.Fatalf("got %d APP1 segments, want only EXIF", len(app1))
+			}
+			checkGPSCleared(t, app1[0][len(exifPrefix):], order, 6)
+		})
+	}
+}
+
+func TestReadJPEGHeaderUnchanged(t *testing.T) {
+	file := testJPEG(t)
+	h, out := readJPEG(t, file)
+	if h.Orientation != 1 {
+		t.Fatalf("Orientation = %d, want 1", h.Orientation)
+	}
+	if !bytes.Equal(out, file) {
+		t.Fatal("file without metadata was changed")
+	}
+}
+
+func TestReadJPEGHeaderInvalid(t *testing.T) {
+	file := testJPEG(t)
+	tests := map[string][]byte{
+		"empty":            nil,
+		"not a JPEG":       []byte("\x89PNG\r\n\x1a\n"),
+		"no marker":        {0xFF, markerSOI, 0x00},
+		"image data first": {0xFF, markerSOI, 0xFF, markerEOI},
+		"short length":     {0xFF, markerSOI, 0xFF, markerAPP1, 0x00, 0x01},
+		"cut in segment":   {0xFF, markerSOI, 0xFF, markerAPP1, 0x00, 0x10, 'E', 'x'},
+		"cut in header":    file[:20],
+	}
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := ReadJPEGHeader(bytes.NewReader(file)); !errors.Is(err, ErrInvalidJPEG) {
+				t.Fatalf("ReadJPEGHeader() error = %v, want %v", err, ErrInvalidJPEG)
+			}
+		})
+	}
+}
+
+// Offsets in EXIF are untrusted: bad ones must not panic, and directories
+// that cannot be found are left alone.
+func TestScrubExifBadOffsets(t *testing.T) {
+	order := binary.LittleEndian
+	tests := []struct {
+		name      string
+		corrupt   func(tiff []byte) []byte
+		unchanged bool
+	}{
+		{"truncated header", func(tiff []byte) []byte { return tiff[:6] }, true},
+		{"unknown order", func(tiff []byte) []byte { copy(tiff, "XX"); return tiff }, true},
+		{"IFD0 past end", func(tiff []byte) []byte {
+			order.PutUint32(tiff[4:], 0xFFFFFFFF)
+			return tiff
+		}, true},
+		{"IFD0 count past end", func(tiff []byte) []byte {
+			order.PutUint16(tiff[exifIFD0:], 0xFFFF)
+			return tiff
+		}, true},
+		{"GPS IFD past end", func(tiff []byte) []byte {
+			order.PutUint32(tiff[exifIFD0+2+12+8:], 0xFFFFFFF0)
+			return tiff
+		}, true},
+		{"GPS IFD cut", func(tiff []byte) []byte { return tiff[:exifGPSIFD+2+12] }, true},
+		{"GPS value past end", func(tiff []byte) []byte {
+			order.PutUint32(tiff[exifGPSIFD+2+12+8:], 0xFFFFFFFF)
+			return tiff
+		}, false},
+		{"GPS value count overflows", func(tiff []byte) []byte {
+			order.PutUint32(tiff[exifGPSIFD+2+12+4:], 0xFFFFFFFF)
+			return tiff
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tiff := tt.corrupt(testExif(order, 3))
+			before := bytes.Clone(tiff)
+			scrubExif(tiff)
+			if tt.unchanged && !bytes.Equal(tiff, before) {
+				t.Fatal("data changed")
+			}
+		})
+	}
+
+	// A GPS directory pointing back at IFD0 is cleared like any other; the
+	// orientation was read before.
+	tiff := testExif(order, 3)
+	order.PutUint32(tiff[exifIFD0+2+12+8:], exifIFD0)
+	if o := scrubExif(tiff); o != 3 {
+		t.Fatalf("orientation = %d, want 3", o)
+	}
+}
+
+func TestClearIFD(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			tiff := testExif(order, 1)
+			clearIFD(tiff, order, exifGPSIFD)
+			checkGPSCleared(t, tiff, order, 1)
+
+			// Clearing again, or at an offset outside the data, is harmless.
+			clearIFD(tiff, order, exifGPSIFD)
+			clearIFD(tiff, order, uint32(len(tiff)-1))
+			clearIFD(tiff, order, 0xFFFFFFFF)
+			checkGPSCleared(t, tiff, order, 1)
+		})
+	}
+}
+
+func FuzzReadJPEGHeader(f *testing.F) {
+	f.Add(testJPEG(f))
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		f.Add(testJPEG(f, testAPP1(exifPrefix, testExif(order, 6))))
+	}
+	f.Add(testJPEG(f, testAPP1(xmpPrefix, []byte("<x:xmpmeta/>"))))
+
+	f.Fuzz(func(t *testing.T, file []byte) {
+		r := bytes.NewReader(file)
+		h, err := ReadJPEGHeader(r)
+		if err != nil {
+			return
+		}
+		if h.Consumed > int64(len(file)) || h.Consumed != int64(len(file))-int64(r.Len()) {
+			t.Fatalf("Consumed = %d, but %d of %d bytes were read", h.Consumed, len(file)-r.Len(), len(file))
+		}
+		if h.Orientation < 1 || h.Orientation > 8 {
+			t.Fatalf("Orientation = %d", h.Orientation)
+		}
+		if int64(len(h.Bytes)) > h.Consumed {
+			t.Fatalf("cleaned header is %d bytes, longer than the %d it replaces", len(h.Bytes), h.Consumed)
+		}
+		if _, err := ReadJPEGHeader(bytes.NewReader(h.Bytes)); err != nil {
+			t.Fatalf("cleaned header does not parse: %v", err)
+		}
+	})
+}
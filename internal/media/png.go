@@ -0,0 +1,114 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+var ErrInvalidPNG = errors.New("media: invalid PNG")
+
+// maxMetadataChunk bounds how much of one EXIF chunk is read to be cleaned;
+// larger chunks are dropped whole.
+const maxMetadataChunk = maxJPEGHeader
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// Keywords of PNG text chunks that hold XMP or raw EXIF, as written by
+// Adobe tools and ImageMagick.
+var pngMetadataKeywords = [][]byte{
+	[]byte("XML:com.adobe.xmp\x00"),
+	[]byte("Raw profile type exif\x00"),
+	[]byte("Raw profile type APP1\x00"),
+	[]byte("Raw profile type xmp\x00"),
+}
+
+// CleanPNG reads the chunk structure of r and returns how to rewrite it
+// without location data: the GPS directory of an eXIf chunk is emptied, as
+// for JPEG, and text chunks carrying XMP or raw EXIF are dropped, as is
+// anything after IEND. Image data is skipped over, not read.
+func CleanPNG(r io.ReadSeeker) (*Rewrite, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var sig [8]byte
+	if _, err := io.ReadFull(r, sig[:]); err != nil || !bytes.Equal(sig[:], pngSignature) {
+		return nil, ErrInvalidPNG
+	}
+	rw := &Rewrite{}
+	rw.keep(0, int64(len(sig)))
+
+	offset := int64(len(sig))
+	var head [8]byte
+	for {
+		if offset == size {
+			// No IEND; decoders tolerate that as long as the image data
+			// is complete.
+			return rw, nil
+		}
+		if _, err := io.ReadFull(r, head[:]); err != nil {
+			return nil, ErrInvalidPNG
+		}
+		n := int64(binary.BigEndian.Uint32(head[:4]))
+		typ := string(head[4:8])
+		// Chunk length, type, data and CRC.
+		total := 12 + n
+		if offset+total > size {
+			return nil, ErrInvalidPNG
+		}
+
+		switch typ {
+		case "eXIf":
+			if n <= maxMetadataChunk {
+				data := make([]byte, n)
+				if _, err := io.ReadFull(r, data); err != nil {
+					return nil, ErrInvalidPNG
+				}
+				scrubExif(data)
+				rw.insert(pngChunk(head[:], data))
+			}
+		case "tEXt", "zTXt", "iTXt":
+			prefix := make([]byte, min(n, 80))
+			if _, err := io.ReadFull(r, prefix); err != nil {
+				return nil, ErrInvalidPNG
+			}
+			if !hasMetadataKeyword(prefix) {
+				rw.keep(offset, total)
+			}
+		default:
+			rw.keep(offset, total)
+		}
+
+		offset += total
+		if typ == "IEND" {
+			return rw, nil
+		}
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, ErrInvalidPNG
+		}
+	}
+}
+
+func hasMetadataKeyword(prefix []byte) bool {
+	for _, k := range pngMetadataKeywords {
+		if bytes.HasPrefix(prefix, k) {
+			return true
+		}
+	}
+	return false
+}
+
+// pngChunk assembles a chunk from its 8-byte length and type header and its
+// data, computing the CRC.
+func pngChunk(head, data []byte) []byte {
+	chunk := make([]byte, 0, len(head)+len(data)+4)
+	chunk = append(chunk, head...)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
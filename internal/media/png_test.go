@@ -0,0 +1,125 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+// testPNG encodes a small image and inserts the given chunks after IHDR.
+func testPNG(t *testing.T, chunks ...[]byte) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 1, color.NRGBA{R: 200, A: 128})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	encoded := buf.Bytes()
+	// Signature, then IHDR: length, type, 13 bytes of data and the CRC.
+	ihdrEnd := 8 + 12 + 13
+	file := append([]byte{}, encoded[:ihdrEnd]...)
+	for _, c := range chunks {
+		file = append(file, c...)
+	}
+	return append(file, encoded[ihdrEnd:]...)
+}
+
+func testPNGChunk(typ string, data []byte) []byte {
+	head := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+	return pngChunk(append(head, typ...), data)
+}
+
+// pngChunks lists the chunks of a PNG file by type.
+func pngChunks(t *testing.T, file []byte) map[string][][]byte {
+	t.Helper()
+	chunks := map[string][][]byte{}
+	for rest := file[8:]; len(rest) > 0; {
+		n := binary.BigEndian.Uint32(rest)
+		typ := string(rest[4:8])
+		chunks[typ] = append(chunks[typ], rest[8:8+n])
+		rest = rest[12+n:]
+	}
+	return chunks
+}
+
+func cleanPNG(t *testing.T, file []byte) []byte {
+	t.Helper()
+	r := bytes.NewReader(file)
+	rw, err := CleanPNG(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(rw.Reader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(out)) != rw.Size {
+		t.Fatalf("Size = %d, but %d bytes were written", rw.Size, len(out))
+	}
+	return out
+}
+
+func TestCleanPNG(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			file := testPNG(t,
+				testPNGChunk("eXIf", testExif(order, 6)),
+				testPNGChunk("iTXt", []byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00<x:xmpmeta/>")),
+				testPNGChunk("zTXt", []byte("Raw profile type exif\x00\x00compressed")),
+				testPNGChunk("tEXt", []byte("Comment\x00kept")),
+			)
+			file = append(file, "trailing data"...)
+
+			out := cleanPNG(t, file)
+			// The decoder checks every CRC, the rewritten eXIf's included.
+			if _, err := png.Decode(bytes.NewReader(out)); err != nil {
+				t.Fatalf("cleaned file does not decode: %v", err)
+			}
+			chunks := pngChunks(t, out)
+			if len(chunks["iTXt"]) != 0 || len(chunks["zTXt"]) != 0 {
+				t.Fatal("XMP or raw EXIF text chunk kept")
+			}
+			if len(chunks["tEXt"]) != 1 {
+				t.Fatal("comment dropped")
+			}
+			if len(chunks["eXIf"]) != 1 {
+				t.Fatal("eXIf dropped")
+			}
+			checkGPSCleared(t, chunks["eXIf"][0], order, 6)
+			if !bytes.HasSuffix(out, []byte("IEND\xaeB`\x82")) {
+				t.Fatal("data after IEND kept")
+			}
+		})
+	}
+}
+
+func TestCleanPNGUnchanged(t *testing.T) {
+	file := testPNG(t)
+	if out := cleanPNG(t, file); !bytes.Equal(out, file) {
+		t.Fatal("file without metadata was changed")
+	}
+}
+
+func TestCleanPNGInvalid(t *testing.T) {
+	file := testPNG(t, testPNGChunk("eXIf", testExif(binary.BigEndian, 1)))
+	tests := map[string][]byte{
+		"empty":         nil,
+		"not a PNG":     []byte("GIF89a"),
+		"truncated":     file[:len(file)-20],
+		"cut in header": file[:8+4],
+		"huge chunk":    append(file[:8:8], 0xFF, 0xFF, 0xFF, 0xF0, 'I', 'D', 'A', 'T'),
+	}
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := CleanPNG(bytes.NewReader(file)); !errors.Is(err, ErrInvalidPNG) {
+				t.Fatalf("CleanPNG() error = %v, want %v", err, ErrInvalidPNG)
+			}
+		})
+	}
+}
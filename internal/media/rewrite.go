@@ -0,0 +1,96 @@
+package media
+
+import (
+	"errors"
+	"io"
+)
+
+// A Rewrite describes a cleaned copy of a file as a sequence of pieces, each
+// either a range of the original or replacement bytes. Building it takes one
+// pass over the original that seeks past image data; Reader then streams the
+// copy, so large files are never held in memory.
+type Rewrite struct {
+	// Size is the length of the cleaned copy.
+	Size   int64
+	pieces []piece
+}
+
+type piece struct {
+	offset, length int64
+	data           []byte
+}
+
+// keep copies length bytes of the original starting at offset.
+func (rw *Rewrite) keep(offset, length int64) {
+	if length == 0 {
+		return
+	}
+	rw.Size += length
+	if n := len(rw.pieces); n > 0 {
+		last := &rw.pieces[n-1]
+		if last.data == nil && last.offset+last.length == offset {
+			last.length += length
+			return
+		}
+	}
+	rw.pieces = append(rw.pieces, piece{offset: offset, length: length})
+}
+
+// insert adds bytes that are not in the original.
+func (rw *Rewrite) insert(data []byte) {
+	rw.Size += int64(len(data))
+	rw.pieces = append(rw.pieces, piece{data: data})
+}
+
+// Reader returns the cleaned copy, reading the kept ranges from r, which must
+// be the original the Rewrite was built from.
+func (rw *Rewrite) Reader(r io.ReadSeeker) io.Reader {
+	return &rewriteReader{r: r, pieces: rw.pieces}
+}
+
+type rewriteReader struct {
+	r      io.ReadSeeker
+	pieces []piece
+	// left is how much of pieces[0] is still to be read once seeked.
+	left   int64
+	seeked bool
+}
+
+var errShortOriginal = errors.New("media: original is shorter than when it was read")
+
+func (rr *rewriteReader) Read(p []byte) (int, error) {
+	for len(rr.pieces) > 0 {
+		cur := &rr.pieces[0]
+		if cur.data != nil {
+			if len(cur.data) == 0 {
+				rr.pieces = rr.pieces[1:]
+				continue
+			}
+			n := copy(p, cur.data)
+			cur.data = cur.data[n:]
+			return n, nil
+		}
+
+		if !rr.seeked {
+			if _, err := rr.r.Seek(cur.offset, io.SeekStart); err != nil {
+				return 0, err
+			}
+			rr.left = cur.length
+			rr.seeked = true
+		}
+		if rr.left == 0 {
+			rr.pieces = rr.pieces[1:]
+			rr.seeked = false
+			continue
+		}
+		n, err := rr.r.Read(p[:min(int64(len(p)), rr.left)])
+		rr.left -= int64(n)
+		if err == io.EOF && rr.left > 0 {
+			err = errShortOriginal
+		} else if err == io.EOF {
+			err = nil
+		}
+		return n, err
+	}
+	return 0, io.EOF
+}
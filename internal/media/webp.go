@@ -0,0 +1,100 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrInvalidWebP = errors.New("media: invalid WebP")
+
+// Flags of the VP8X chunk announcing metadata chunks.
+const (
+	vp8xFlagEXIF = 0x08
+	vp8xFlagXMP  = 0x04
+)
+
+// CleanWebP reads the chunk structure of r and returns how to rewrite it
+// without location data: the GPS directory of the EXIF chunk is emptied, as
+// for JPEG, and the XMP chunk is dropped, with the RIFF size and VP8X flags
+// updated to match. Image data is skipped over, not read.
+func CleanWebP(r io.ReadSeeker) (*Rewrite, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:4]) != "RIFF" || string(header[8:]) != "WEBP" {
+		return nil, ErrInvalidWebP
+	}
+	end := 8 + int64(binary.LittleEndian.Uint32(header[4:8]))
+	if end > size {
+		return nil, ErrInvalidWebP
+	}
+	rw := &Rewrite{}
+	// The RIFF size is filled in once the rest is known.
+	rw.insert(header)
+
+	var vp8x []byte
+	var dropped byte
+	offset := int64(len(header))
+	for offset < end {
+		head := make([]byte, 8)
+		if _, err := io.ReadFull(r, head); err != nil {
+			return nil, ErrInvalidWebP
+		}
+		n := int64(binary.LittleEndian.Uint32(head[4:]))
+		if offset+8+n > end {
+			return nil, ErrInvalidWebP
+		}
+		// Chunks are padded to an even size; tolerate a missing pad at the
+		// very end.
+		total := min(8+n+n&1, end-offset)
+
+		switch string(head[:4]) {
+		case "VP8X":
+			if n < 10 {
+				return nil, ErrInvalidWebP
+			}
+			chunk := make([]byte, total)
+			copy(chunk, head)
+			if _, err := io.ReadFull(r, chunk[8:]); err != nil {
+				return nil, ErrInvalidWebP
+			}
+			vp8x = chunk[8:]
+			rw.insert(chunk)
+		case "EXIF":
+			if n > maxMetadataChunk {
+				dropped |= vp8xFlagEXIF
+				break
+			}
+			chunk := make([]byte, total)
+			copy(chunk, head)
+			if _, err := io.ReadFull(r, chunk[8:]); err != nil {
+				return nil, ErrInvalidWebP
+			}
+			// Some writers keep the JPEG APP1 prefix.
+			scrubExif(bytes.TrimPrefix(chunk[8:8+n], exifPrefix))
+			rw.insert(chunk)
+		case "XMP ":
+			dropped |= vp8xFlagXMP
+		default:
+			rw.keep(offset, total)
+		}
+
+		offset += total
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			return nil, ErrInvalidWebP
+		}
+	}
+
+	if vp8x != nil {
+		vp8x[0] &^= dropped
+	}
+	binary.LittleEndian.PutUint32(header[4:8], uint32(rw.Size-8))
+	return rw, nil
+}
@@ -0,0 +1,140 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"testing"
+
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/webp"
+)
+
+// testWebP encodes a small image as an extended WebP file, with a VP8X
+// header announcing flags, followed by the image data and the given chunks.
+func testWebP(t *testing.T, flags byte, chunks ...[]byte) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 1, color.NRGBA{R: 200, A: 128})
+	var buf bytes.Buffer
+	if err := nativewebp.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Past the RIFF header is the VP8L chunk.
+	vp8l := buf.Bytes()[12:]
+
+	vp8x := []byte{flags | 0x10, 0, 0, 0, 3, 0, 0, 2, 0, 0}
+	body := append([]byte("WEBP"), testWebPChunk("VP8X", vp8x)...)
+	body = append(body, vp8l...)
+	for _, c := range chunks {
+		body = append(body, c...)
+	}
+	file := binary.LittleEndian.AppendUint32([]byte("RIFF"), uint32(len(body)))
+	return append(file, body...)
+}
+
+func testWebPChunk(fourcc string, data []byte) []byte {
+	chunk := binary.LittleEndian.AppendUint32([]byte(fourcc), uint32(len(data)))
+	chunk = append(chunk, data...)
+	if len(data)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+	return chunk
+}
+
+// webpChunks lists the chunks of a WebP file by FourCC.
+func webpChunks(t *testing.T, file []byte) map[string][]byte {
+	t.Helper()
+	if got := int(binary.LittleEndian.Uint32(file[4:])); got != len(file)-8 {
+		t.Fatalf("RIFF size = %d, want %d", got, len(file)-8)
+	}
+	chunks := map[string][]byte{}
+	for rest := file[12:]; len(rest) > 0; {
+		n := binary.LittleEndian.Uint32(rest[4:])
+		chunks[string(rest[:4])] = rest[8 : 8+n]
+		rest = rest[min(len(rest), int(8+n+n&1)):]
+	}
+	return chunks
+}
+
+func cleanWebP(t *testing.T, file []byte) []byte {
+	t.Helper()
+	r := bytes.NewReader(file)
+	rw, err := CleanWebP(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := io.ReadAll(rw.Reader(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if int64(len(out)) != rw.Size {
+		t.Fatalf("Size = %d, but %d bytes were written", rw.Size, len(out))
+	}
+	return out
+}
+
+func TestCleanWebP(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.BigEndian, binary.LittleEndian} {
+		for _, prefix := range []string{"", "Exif\x00\x00"} {
+			name := order.String()
+			if prefix != "" {
+				name += " with APP1 prefix"
+			}
+			t.Run(name, func(t *testing.T) {
+				exif := append([]byte(prefix), testExif(order, 8)...)
+				file := testWebP(t, vp8xFlagEXIF|vp8xFlagXMP,
+					testWebPChunk("EXIF", exif),
+					// Odd sized, so followed by a pad byte.
+					testWebPChunk("XMP ", []byte("<x:xmpmeta/>\n")),
+					testWebPChunk("ZZZZ", []byte("kept")),
+				)
+
+				out := cleanWebP(t, file)
+				if _, err := webp.Decode(bytes.NewReader(out)); err != nil {
+					t.Fatalf("cleaned file does not decode: %v", err)
+				}
+				chunks := webpChunks(t, out)
+				if _, ok := chunks["XMP "]; ok {
+					t.Fatal("XMP kept")
+				}
+				if string(chunks["ZZZZ"]) != "kept" {
+					t.Fatal("unknown chunk dropped")
+				}
+				if got := chunks["VP8X"][0]; got != 0x10|vp8xFlagEXIF {
+					t.Fatalf("VP8X flags = %#x, want %#x", got, 0x10|vp8xFlagEXIF)
+				}
+				checkGPSCleared(t, bytes.TrimPrefix(chunks["EXIF"], exifPrefix), order, 8)
+			})
+		}
+	}
+}
+
+func TestCleanWebPUnchanged(t *testing.T) {
+	file := testWebP(t, 0)
+	if out := cleanWebP(t, file); !bytes.Equal(out, file) {
+		t.Fatal("file without metadata was changed")
+	}
+}
+
+func TestCleanWebPInvalid(t *testing.T) {
+	file := testWebP(t, vp8xFlagEXIF, testWebPChunk("EXIF", testExif(binary.LittleEndian, 1)))
+	tests := map[string][]byte{
+		"empty":       nil,
+		"not a WebP":  []byte("RIFF\x04\x00\x00\x00WAVE"),
+		"truncated":   file[:len(file)-20],
+		"short VP8X":  append(binary.LittleEndian.AppendUint32([]byte("RIFF"), 4+8+2), "WEBPVP8X\x02\x00\x00\x00\x00\x00"...),
+		"huge chunk":  append(binary.LittleEndian.AppendUint32([]byte("RIFF"), 4+8), "WEBPVP8L\xF0\xFF\xFF\xFF"...),
+		"cut in head": append(binary.LittleEndian.AppendUint32([]byte("RIFF"), 4+4), "WEBPVP8L"...),
+	}
+	for name, file := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := CleanWebP(bytes.NewReader(file)); !errors.Is(err, ErrInvalidWebP) {
+				t.Fatalf("CleanWebP() error = %v, want %v", err, ErrInvalidWebP)
+			}
+		})
+	}
+}
@@ -1,39 +1,87 @@
 package middleware
 
 import (
-	"net/http"
+	"log/slog"
 	"strings"
 
+	"github.com/DjMariarty/messenger/internal/apperr"
 	"github.com/DjMariarty/messenger/internal/auth"
+	"github.com/DjMariarty/messenger/internal/logger"
 	"github.com/gin-gonic/gin"
 )
 
-func AuthRequired() gin.HandlerFunc {
+var (
+	ErrMissingToken  = apperr.New(apperr.Unauthenticated, "missing_token", "missing token")
+	ErrInvalidHeader = apperr.New(apperr.Unauthenticated, "invalid_authorization_header", "invalid authorization header")
+	ErrInvalidToken  = apperr.New(apperr.Unauthenticated, "invalid_token", "invalid token")
+	ErrTokenRevoked  = apperr.New(apperr.Unauthenticated, "token_revoked", "token revoked")
+)
+
+func AuthRequired(revocations auth.RevocationList) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		
+
 		header := c.GetHeader("Authorization")
 		if header == "" {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "missing token"})
+			abort(c, ErrMissingToken)
 			return
 		}
 
-		
 		if !strings.HasPrefix(header, "Bearer ") {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid authorization header"})
+			abort(c, ErrInvalidHeader)
 			return
 		}
 
-		
 		tokenStr := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		authenticate(c, revocations, tokenStr)
+	}
+}
 
-		claims, err := auth.ParseToken(tokenStr)
-		if err != nil {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
+// QueryAuth works like AuthRequired but also accepts the token in the
+// access_token query parameter, for requests browsers make without letting
+// us set headers: websocket upgrades and <img>/<a> attachment downloads.
+func QueryAuth(revocations auth.RevocationList) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenStr := c.Query("access_token")
+		if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+			tokenStr = strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+		}
+		if tokenStr == "" {
+			abort(c, ErrMissingToken)
 			return
 		}
 
-		
-		c.Set("user_id", claims.UserID)
-		c.Next()
+		authenticate(c, revocations, tokenStr)
+	}
+}
+
+func authenticate(c *gin.Context, revocations auth.RevocationList, tokenStr string) {
+	claims, err := auth.ParseToken(tokenStr)
+	if err != nil {
+		abort(c, ErrInvalidToken.Wrap(err))
+		return
+	}
+
+	revoked, err := revocations.IsRevoked(c.Request.Context(), claims)
+	if err != nil {
+		abort(c, err)
+		return
 	}
+	if revoked {
+		abort(c, ErrTokenRevoked)
+		return
+	}
+
+	c.Set("user_id", claims.UserID)
+	c.Set("claims", claims)
+	c.Set("locale", claims.Locale)
+	c.Request = c.Request.WithContext(logger.WithAttrs(c.Request.Context(),
+		slog.Uint64("user_id", uint64(claims.UserID)),
+	))
+	c.Next()
+}
+
+// abort stops the chain and leaves err for the Errors middleware to render.
+func abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
 }
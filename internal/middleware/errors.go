@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/i18n"
+	"github.com/gin-gonic/gin"
+)
+
+// statusClientClosedRequest is the nginx convention for a request whose
+// client went away before the response was ready.
+const statusClientClosedRequest = 499
+
+var kindStatus = map[apperr.Kind]int{
+	apperr.Internal:        http.StatusInternalServerError,
+	apperr.Invalid:         http.StatusBadRequest,
+	apperr.Unauthenticated: http.StatusUnauthorized,
+	apperr.Forbidden:       http.StatusForbidden,
+	apperr.NotFound:        http.StatusNotFound,
+	apperr.Conflict:        http.StatusConflict,
+	apperr.Timeout:         http.StatusGatewayTimeout,
+	apperr.Canceled:        statusClientClosedRequest,
+
+	apperr.TooLarge:             http.StatusRequestEntityTooLarge,
+	apperr.UnsupportedMediaType: http.StatusUnsupportedMediaType,
+}
+
+type errorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// Errors renders the last error a handler attached with c.Error as
+// {code, message, details}. Handlers and middleware only record errors; this
+// is the one place that decides statuses and what the client gets to see.
+// Messages are translated into the language negotiated by i18n.Negotiate
+// from the user's profile locale and Accept-Language.
+//
+// It has to run inside the metrics and access log middleware so that they
+// observe the status it writes.
+func Errors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		e := resolve(c.Request.Context(), c.Errors.Last().Err)
+
+		status := kindStatus[e.Kind]
+		if e.Kind == apperr.Canceled {
+			// Nobody is listening for a body.
+			c.AbortWithStatus(status)
+			return
+		}
+
+		lang := i18n.Negotiate(c.GetString("locale"), c.GetHeader("Accept-Language"))
+		c.Header("Content-Language", lang)
+		c.AbortWithStatusJSON(status, localize(e, lang))
+	}
+}
+
+// resolve picks the error to report. When the request deadline passed or the
+// client disconnected, whatever the handler saw is a symptom, so the response
+// says so instead of reporting a server fault.
+func resolve(ctx context.Context, err error) *apperr.Error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		err = ctxErr
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return apperr.ErrTimeout.Wrap(err)
+	case errors.Is(err, context.Canceled):
+		return apperr.ErrCanceled.Wrap(err)
+	}
+	return apperr.From(err)
+}
+
+func localize(e *apperr.Error, lang string) errorBody {
+	body := errorBody{Code: e.Code, Message: e.Message, Details: e.Details}
+	if msg, ok := i18n.Translate(lang, e.Code); ok {
+		body.Message = msg
+	}
+
+	if v, ok := e.Details.(apperr.ValidationDetails); ok {
+		fields := make([]apperr.FieldError, len(v.Fields))
+		for i, f := range v.Fields {
+			if msg, ok := i18n.Translate(lang, f.Key, f.Args...); ok {
+				f.Message = msg
+			}
+			fields[i] = f
+		}
+		body.Details = apperr.ValidationDetails{Fields: fields}
+	}
+	return body
+}
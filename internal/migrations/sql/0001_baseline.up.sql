@@ -0,0 +1,41 @@
+-- Schema as it was created by GORM AutoMigrate before versioned migrations.
+-- Everything is IF NOT EXISTS so that existing databases adopt it as is.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            bigserial PRIMARY KEY,
+    created_at    timestamptz,
+    updated_at    timestamptz,
+    deleted_at    timestamptz,
+    name          text NOT NULL,
+    email         text NOT NULL,
+    password_hash text NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+
+CREATE TABLE IF NOT EXISTS chats (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    user1_id   bigint NOT NULL,
+    user2_id   bigint NOT NULL,
+    CONSTRAINT fk_chats_user1 FOREIGN KEY (user1_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_chats_user2 FOREIGN KEY (user2_id) REFERENCES users (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_chats_deleted_at ON chats (deleted_at);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id         bigserial PRIMARY KEY,
+    created_at timestamptz,
+    updated_at timestamptz,
+    deleted_at timestamptz,
+    chat_id    bigint NOT NULL,
+    sender_id  bigint NOT NULL,
+    text       text NOT NULL,
+    CONSTRAINT fk_chats_messages FOREIGN KEY (chat_id) REFERENCES chats (id),
+    CONSTRAINT fk_users_messages FOREIGN KEY (sender_id) REFERENCES users (id)
+);
+CREATE INDEX IF NOT EXISTS idx_messages_deleted_at ON messages (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages (chat_id);
+CREATE INDEX IF NOT EXISTS idx_messages_sender_id ON messages (sender_id);
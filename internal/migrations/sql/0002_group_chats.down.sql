@@ -0,0 +1,13 @@
+-- Group chats cannot be represented without chat_members.
+DELETE FROM messages WHERE chat_id IN (SELECT id FROM chats WHERE type <> 'direct');
+DELETE FROM chats WHERE type <> 'direct';
+
+DROP TABLE IF EXISTS chat_members;
+
+DROP INDEX IF EXISTS idx_chats_direct_pair;
+DROP INDEX IF EXISTS idx_chats_type;
+ALTER TABLE chats
+    ALTER COLUMN user1_id SET NOT NULL,
+    ALTER COLUMN user2_id SET NOT NULL,
+    DROP COLUMN IF EXISTS title,
+    DROP COLUMN IF EXISTS type;
@@ -0,0 +1,32 @@
+ALTER TABLE chats
+    ADD COLUMN IF NOT EXISTS type text NOT NULL DEFAULT 'direct',
+    ADD COLUMN IF NOT EXISTS title text NOT NULL DEFAULT '',
+    ALTER COLUMN user1_id DROP NOT NULL,
+    ALTER COLUMN user2_id DROP NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_chats_type ON chats (type);
+
+-- Only direct chats are unique per pair of users.
+DROP INDEX IF EXISTS idx_chats_direct_pair;
+CREATE UNIQUE INDEX idx_chats_direct_pair ON chats (user1_id, user2_id) WHERE type = 'direct';
+
+CREATE TABLE IF NOT EXISTS chat_members (
+    chat_id              bigint NOT NULL,
+    user_id              bigint NOT NULL,
+    role                 text NOT NULL DEFAULT 'member',
+    joined_at            timestamptz NOT NULL,
+    last_read_message_id bigint,
+    PRIMARY KEY (chat_id, user_id),
+    CONSTRAINT fk_chats_members FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE,
+    CONSTRAINT fk_chat_members_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+ALTER TABLE chat_members
+    ADD COLUMN IF NOT EXISTS role text NOT NULL DEFAULT 'member',
+    ADD COLUMN IF NOT EXISTS last_read_message_id bigint;
+CREATE INDEX IF NOT EXISTS idx_chat_members_user_id ON chat_members (user_id);
+
+-- Direct chats from before chat_members keep working.
+INSERT INTO chat_members (chat_id, user_id, joined_at)
+SELECT id, user1_id, created_at FROM chats WHERE user1_id IS NOT NULL
+UNION ALL
+SELECT id, user2_id, created_at FROM chats WHERE user2_id IS NOT NULL
+ON CONFLICT DO NOTHING;
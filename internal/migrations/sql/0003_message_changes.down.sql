@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS hidden_messages;
+DROP TABLE IF EXISTS message_edits;
+
+CREATE INDEX IF NOT EXISTS idx_messages_chat_id ON messages (chat_id);
+DROP INDEX IF EXISTS idx_messages_chat_history;
+
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS removed_at,
+    DROP COLUMN IF EXISTS edited_at,
+    DROP COLUMN IF EXISTS type;
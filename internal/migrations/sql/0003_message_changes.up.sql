@@ -0,0 +1,27 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS type text NOT NULL DEFAULT 'text',
+    ADD COLUMN IF NOT EXISTS edited_at timestamptz,
+    ADD COLUMN IF NOT EXISTS removed_at timestamptz;
+
+-- History pagination walks (chat_id, created_at, id); the old chat_id index
+-- is a prefix of it.
+CREATE INDEX IF NOT EXISTS idx_messages_chat_history ON messages (chat_id, created_at, id);
+DROP INDEX IF EXISTS idx_messages_chat_id;
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id         bigserial PRIMARY KEY,
+    message_id bigint NOT NULL,
+    text       text NOT NULL,
+    created_at timestamptz NOT NULL,
+    CONSTRAINT fk_message_edits_message FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_message_edits_message_id ON message_edits (message_id);
+
+CREATE TABLE IF NOT EXISTS hidden_messages (
+    message_id bigint NOT NULL,
+    user_id    bigint NOT NULL,
+    created_at timestamptz,
+    PRIMARY KEY (message_id, user_id),
+    CONSTRAINT fk_hidden_messages_message FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE CASCADE,
+    CONSTRAINT fk_hidden_messages_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
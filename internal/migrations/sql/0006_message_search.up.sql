@@ -0,0 +1,7 @@
+-- Each message is indexed with the text search configuration it was written
+-- under, so the server default can change without reindexing history.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_language regconfig NOT NULL DEFAULT 'russian';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS search_vector tsvector
+    GENERATED ALWAYS AS (to_tsvector(search_language, text)) STORED;
+
+CREATE INDEX IF NOT EXISTS idx_messages_search ON messages USING gin (search_vector);
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS attachments (
+    id          bigserial PRIMARY KEY,
+    created_at  timestamptz,
+    chat_id     bigint NOT NULL,
+    uploader_id bigint NOT NULL,
+    message_id  bigint,
+    storage_key text NOT NULL,
+    file_name   text NOT NULL,
+    mime_type   text NOT NULL,
+    size        bigint NOT NULL,
+    checksum    text NOT NULL,
+    width       integer,
+    height      integer,
+    CONSTRAINT fk_attachments_chat FOREIGN KEY (chat_id) REFERENCES chats (id) ON DELETE CASCADE,
+    CONSTRAINT fk_attachments_uploader FOREIGN KEY (uploader_id) REFERENCES users (id) ON DELETE CASCADE,
+    CONSTRAINT fk_attachments_message FOREIGN KEY (message_id) REFERENCES messages (id) ON DELETE SET NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachments_storage_key ON attachments (storage_key);
+CREATE INDEX IF NOT EXISTS idx_attachments_chat_id ON attachments (chat_id);
+CREATE INDEX IF NOT EXISTS idx_attachments_message_id ON attachments (message_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS attachment_thumbnails;
+DROP INDEX IF EXISTS idx_attachments_thumbnail_pending;
+ALTER TABLE attachments DROP COLUMN IF EXISTS thumbnail_state;
@@ -0,0 +1,14 @@
+ALTER TABLE attachments ADD COLUMN IF NOT EXISTS thumbnail_state text NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS attachment_thumbnails (
+    id            bigserial PRIMARY KEY,
+    attachment_id bigint NOT NULL,
+    size          integer NOT NULL,
+    storage_key   text NOT NULL,
+    width         integer NOT NULL,
+    height        integer NOT NULL,
+    bytes         bigint NOT NULL,
+    CONSTRAINT fk_attachment_thumbnails_attachment FOREIGN KEY (attachment_id) REFERENCES attachments (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_attachment_thumbnails_size ON attachment_thumbnails (attachment_id, size);
+CREATE INDEX IF NOT EXISTS idx_attachments_thumbnail_pending ON attachments (id) WHERE thumbnail_state = 'pending';
@@ -0,0 +1,5 @@
+-- The inline reference is only created together with the column, which keeps
+-- the migration idempotent.
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS reply_to_message_id bigint REFERENCES messages (id) ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_messages_reply_to_message_id ON messages (reply_to_message_id);
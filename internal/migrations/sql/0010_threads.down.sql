@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS thread_reads;
+DROP INDEX IF EXISTS idx_messages_thread;
+ALTER TABLE messages
+    DROP COLUMN IF EXISTS last_reply_at,
+    DROP COLUMN IF EXISTS reply_count,
+    DROP COLUMN IF EXISTS thread_root_id;
@@ -0,0 +1,16 @@
+ALTER TABLE messages
+    ADD COLUMN IF NOT EXISTS thread_root_id bigint REFERENCES messages (id) ON DELETE CASCADE,
+    ADD COLUMN IF NOT EXISTS reply_count integer NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS last_reply_at timestamptz;
+-- Thread pages, like chat history, are keyset-paginated by (created_at, id).
+CREATE INDEX IF NOT EXISTS idx_messages_thread ON messages (thread_root_id, created_at, id)
+    WHERE thread_root_id IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS thread_reads (
+    root_message_id      bigint NOT NULL,
+    user_id              bigint NOT NULL,
+    last_read_message_id bigint NOT NULL,
+    PRIMARY KEY (root_message_id, user_id),
+    CONSTRAINT fk_thread_reads_root FOREIGN KEY (root_message_id) REFERENCES messages (id) ON DELETE CASCADE,
+    CONSTRAINT fk_thread_reads_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
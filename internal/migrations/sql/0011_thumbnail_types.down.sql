@@ -0,0 +1 @@
+ALTER TABLE attachment_thumbnails DROP COLUMN IF EXISTS mime_type;
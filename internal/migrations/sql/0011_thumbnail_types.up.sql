@@ -0,0 +1,2 @@
+-- Thumbnails of transparent images are WebP; the ones made before are JPEG.
+ALTER TABLE attachment_thumbnails ADD COLUMN IF NOT EXISTS mime_type text NOT NULL DEFAULT 'image/jpeg';
@@ -0,0 +1,56 @@
+package models
+
+import "time"
+
+// Attachment is a file uploaded into a chat. It belongs to the chat from the
+// start, so only members can fetch it, and is bound to a message once the
+// uploader sends one referencing it.
+type Attachment struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	CreatedAt time.Time `json:"created_at"`
+
+	ChatID     uint  `json:"chat_id" gorm:"not null;index"`
+	UploaderID uint  `json:"uploader_id" gorm:"not null"`
+	MessageID  *uint `json:"message_id" gorm:"index"`
+
+	StorageKey string `json:"-" gorm:"not null;uniqueIndex"`
+	FileName   string `json:"file_name" gorm:"not null"`
+	// MimeType is sniffed from the content, never taken from the client.
+	MimeType string `json:"mime_type" gorm:"not null"`
+	Size     int64  `json:"size" gorm:"not null"`
+	// Checksum is the hex SHA-256 of the content.
+	Checksum string `json:"checksum" gorm:"not null"`
+	// Width and Height are set for images whose format we can decode.
+	Width  *int `json:"width"`
+	Height *int `json:"height"`
+	// ThumbnailState tracks thumbnail generation for images, see the
+	// ThumbnailState constants; it is empty for other files.
+	ThumbnailState string                `json:"thumbnail_state" gorm:"not null;default:''"`
+	Thumbnails     []AttachmentThumbnail `json:"thumbnails,omitempty"`
+
+	Chat     Chat     `json:"-" gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE"`
+	Uploader User     `json:"-" gorm:"foreignKey:UploaderID;constraint:OnDelete:CASCADE"`
+	Message  *Message `json:"-" gorm:"foreignKey:MessageID;constraint:OnDelete:SET NULL"`
+}
+
+const (
+	ThumbnailPending = "pending"
+	ThumbnailReady   = "ready"
+	// ThumbnailFailed marks images that could not be decoded; clients show
+	// them as plain files.
+	ThumbnailFailed = "failed"
+)
+
+// AttachmentThumbnail is a downscaled copy of an image attachment, stored
+// next to the original: JPEG, or WebP when the image has transparency.
+type AttachmentThumbnail struct {
+	ID           uint `json:"-" gorm:"primarykey"`
+	AttachmentID uint `json:"-" gorm:"not null;uniqueIndex:idx_attachment_thumbnails_size"`
+	// Size is the bound on the longer side the thumbnail was made for.
+	Size       int    `json:"size" gorm:"not null;uniqueIndex:idx_attachment_thumbnails_size"`
+	StorageKey string `json:"-" gorm:"not null"`
+	MimeType   string `json:"mime_type" gorm:"not null;default:image/jpeg"`
+	Width      int    `json:"width" gorm:"not null"`
+	Height     int    `json:"height" gorm:"not null"`
+	Bytes      int64  `json:"bytes" gorm:"not null"`
+}
@@ -4,13 +4,24 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	ChatTypeDirect = "direct"
+	ChatTypeGroup  = "group"
+)
+
 type Chat struct {
 	gorm.Model
-	User1ID uint `gorm:"not null"`
-	User2ID uint `gorm:"not null"`
+	Type  string `gorm:"not null;default:direct;index"`
+	Title string `gorm:"not null;default:''"`
+
+	// User1ID/User2ID identify the pair of a direct chat (User1ID < User2ID)
+	// and are NULL for group chats. Membership itself lives in chat_members.
+	User1ID *uint `gorm:"uniqueIndex:idx_chats_direct_pair,where:type = 'direct'"`
+	User2ID *uint `gorm:"uniqueIndex:idx_chats_direct_pair,where:type = 'direct'"`
 
-	User1 User `gorm:"foreignKey:User1ID;constraint:OnDelete:CASCADE"`
-	User2 User `gorm:"foreignKey:User2ID;constraint:OnDelete:CASCADE"`
+	User1 *User `gorm:"foreignKey:User1ID;constraint:OnDelete:CASCADE"`
+	User2 *User `gorm:"foreignKey:User2ID;constraint:OnDelete:CASCADE"`
 
-	Messages []Message `gorm:"foreignKey:ChatID"`
+	Members  []ChatMember `gorm:"foreignKey:ChatID;constraint:OnDelete:CASCADE"`
+	Messages []Message    `gorm:"foreignKey:ChatID"`
 }
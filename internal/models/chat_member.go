@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+const (
+	RoleOwner  = "owner"
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+type ChatMember struct {
+	ChatID   uint      `gorm:"primaryKey"`
+	UserID   uint      `gorm:"primaryKey;index"`
+	Role     string    `gorm:"not null;default:member"`
+	JoinedAt time.Time `gorm:"not null;autoCreateTime"`
+
+	// LastReadMessageID is the newest message this member has read; messages
+	// from others after it (and after JoinedAt) count as unread.
+	LastReadMessageID *uint
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
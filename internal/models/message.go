@@ -1,10 +1,80 @@
 package models
 
-import "gorm.io/gorm"
+import (
+	"time"
 
+	"gorm.io/gorm"
+)
+
+const (
+	MessageTypeText = "text"
+	// MessageTypeSystem marks service notes such as "Alice added Bob";
+	// SenderID is the user who triggered them.
+	MessageTypeSystem = "system"
+)
+
+// Message spells out gorm.Model so that history pagination can get a
+// composite (chat_id, created_at, id) index.
 type Message struct {
-	gorm.Model
-	ChatID   uint   `json:"chat_id" gorm:"not null;index"`
+	ID        uint      `gorm:"primarykey;index:idx_messages_chat_history,priority:3"`
+	CreatedAt time.Time `gorm:"index:idx_messages_chat_history,priority:2"`
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+
+	ChatID   uint   `json:"chat_id" gorm:"not null;index:idx_messages_chat_history,priority:1"`
 	SenderID uint   `json:"sender_id" gorm:"not null;index"`
+	Type     string `json:"type" gorm:"not null;default:text"`
 	Text     string `json:"text" gorm:"not null"`
+	// ReplyToMessageID quotes an earlier message of the same chat.
+	ReplyToMessageID *uint    `json:"reply_to_message_id,omitempty" gorm:"index"`
+	ReplyTo          *Message `json:"-" gorm:"foreignKey:ReplyToMessageID;constraint:OnDelete:SET NULL"`
+
+	// ThreadRootID puts the message in the thread of a top-level message of
+	// a group chat. Thread replies stay out of the main history.
+	ThreadRootID *uint `json:"thread_root_id,omitempty"`
+	// ReplyCount and LastReplyAt summarize the thread of a root message,
+	// not counting replies deleted for everyone.
+	ReplyCount  int        `json:"reply_count" gorm:"not null;default:0"`
+	LastReplyAt *time.Time `json:"last_reply_at"`
+
+	EditedAt *time.Time `json:"edited_at"`
+	// RemovedAt is set when the sender deletes the message for everyone.
+	// Text is wiped but the row stays, so history keeps its ordering.
+	RemovedAt *time.Time `json:"removed_at"`
+
+	// SearchLanguage is the text search configuration the generated
+	// search_vector column is built with.
+	SearchLanguage string `json:"-" gorm:"type:regconfig;not null;default:russian"`
+
+	Attachments []Attachment `json:"attachments,omitempty" gorm:"foreignKey:MessageID"`
+}
+
+// MessageEdit keeps a text the message had before an edit.
+type MessageEdit struct {
+	ID        uint      `gorm:"primarykey"`
+	MessageID uint      `gorm:"not null;index"`
+	Text      string    `gorm:"not null"`
+	CreatedAt time.Time `gorm:"not null"`
+
+	Message Message `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE"`
+}
+
+// ThreadRead is how far a user has read the thread of a root message.
+type ThreadRead struct {
+	RootMessageID     uint `gorm:"primaryKey"`
+	UserID            uint `gorm:"primaryKey"`
+	LastReadMessageID uint `gorm:"not null"`
+
+	Root Message `gorm:"foreignKey:RootMessageID;constraint:OnDelete:CASCADE"`
+	User User    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// HiddenMessage records a "delete for me" of a message by one user.
+type HiddenMessage struct {
+	MessageID uint `gorm:"primaryKey"`
+	UserID    uint `gorm:"primaryKey"`
+	CreatedAt time.Time
+
+	Message Message `gorm:"foreignKey:MessageID;constraint:OnDelete:CASCADE"`
+	User    User    `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
 }
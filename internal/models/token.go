@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// Session is one login on one device. Every refresh token and every access
+// token issued from it carries its ID; revoking the session ends them all.
+type Session struct {
+	ID         uint      `gorm:"primarykey"`
+	CreatedAt  time.Time `gorm:"not null"`
+	UserID     uint      `gorm:"not null;index"`
+	UserAgent  string    `gorm:"not null;default:''"`
+	IP         string    `gorm:"not null;default:''"`
+	LastSeenAt time.Time `gorm:"not null"`
+	ExpiresAt  time.Time `gorm:"not null"`
+	RevokedAt  *time.Time
+
+	User User `gorm:"foreignKey:UserID;constraint:OnDelete:CASCADE"`
+}
+
+// RefreshToken is one link of a session's rotating refresh token chain. Only
+// the SHA-256 of the token is stored.
+type RefreshToken struct {
+	ID        uint      `gorm:"primarykey"`
+	CreatedAt time.Time `gorm:"not null"`
+	UserID    uint      `gorm:"not null;index"`
+	SessionID uint      `gorm:"not null;index"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+
+	Session Session `gorm:"foreignKey:SessionID;constraint:OnDelete:CASCADE"`
+}
+
+// RevokedToken lists access tokens (by jti) that must be rejected before
+// they expire.
+type RevokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+	CreatedAt time.Time
+}
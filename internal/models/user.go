@@ -8,6 +8,9 @@ type User struct {
 	Name         string `json:"name" gorm:"not null"`
 	Email        string `json:"email" gorm:"uniqueIndex;not null"`
 	PasswordHash string `json:"-" gorm:"not null"`
+	// Locale is the language the user chose for API messages; empty means
+	// negotiate it from Accept-Language.
+	Locale string `json:"locale" gorm:"not null;default:''"`
 
 	Messages []Message `gorm:"foreignKey:SenderID"`
 }
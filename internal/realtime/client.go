@@ -0,0 +1,112 @@
+package realtime
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	writeWait      = 10 * time.Second
+	pongWait       = 60 * time.Second
+	pingPeriod     = (pongWait * 9) / 10
+	maxMessageSize = 512
+	sendBufferSize = 256
+)
+
+type Client struct {
+	hub    *Hub
+	conn   *websocket.Conn
+	userID uint
+	send   chan []byte
+	// goingAway is set by the hub before closing send during shutdown.
+	goingAway bool
+}
+
+// Serve registers an upgraded connection for userID and starts its pumps.
+func (h *Hub) Serve(conn *websocket.Conn, userID uint) {
+	c := &Client{
+		hub:    h,
+		conn:   conn,
+		userID: userID,
+		send:   make(chan []byte, sendBufferSize),
+	}
+	// Count the write pump before registering so Shutdown cannot miss it.
+	h.writers.Add(1)
+	select {
+	case h.register <- c:
+	case <-h.done:
+		h.writers.Done()
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"),
+			time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+
+	go c.writePump()
+	go c.readPump()
+}
+
+// readPump only exists to process control frames; clients send messages over HTTP.
+func (c *Client) readPump() {
+	defer func() {
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.done:
+		}
+		c.conn.Close()
+	}()
+
+	c.conn.SetReadLimit(maxMessageSize)
+	_ = c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				c.hub.log.Warn("ws client: unexpected close",
+					slog.Uint64("user_id", uint64(c.userID)),
+					slog.Any("error", err),
+				)
+			}
+			return
+		}
+	}
+}
+
+func (c *Client) writePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.conn.Close()
+		c.hub.writers.Done()
+	}()
+
+	for {
+		select {
+		case data, ok := <-c.send:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				msg := []byte{}
+				if c.goingAway {
+					msg = websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+				}
+				_ = c.conn.WriteMessage(websocket.CloseMessage, msg)
+				return
+			}
+			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+
+		case <-ticker.C:
+			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
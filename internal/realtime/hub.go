@@ -0,0 +1,170 @@
+package realtime
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/DjMariarty/messenger/internal/metrics"
+)
+
+type Event struct {
+	Type    string `json:"type"`
+	Payload any    `json:"payload"`
+}
+
+type delivery struct {
+	userIDs []uint
+	data    []byte
+}
+
+// Hub keeps track of connected clients per user and fans events out to them.
+// All maps are owned by the Run goroutine.
+type Hub struct {
+	clients    map[uint]map[*Client]struct{}
+	register   chan *Client
+	unregister chan *Client
+	broadcast  chan delivery
+	log        *slog.Logger
+
+	// done is closed by Shutdown; stopped is closed when Run has returned.
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+	// writers counts running write pumps so Shutdown can wait for close frames.
+	writers sync.WaitGroup
+}
+
+func NewHub(log *slog.Logger) *Hub {
+	return &Hub{
+		clients:    make(map[uint]map[*Client]struct{}),
+		register:   make(chan *Client),
+		unregister: make(chan *Client),
+		broadcast:  make(chan delivery, 256),
+		log:        log,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+	}
+}
+
+// Run serves the hub until Shutdown is called.
+func (h *Hub) Run() {
+	defer close(h.stopped)
+
+	for {
+		select {
+		case <-h.done:
+			h.closeAll()
+			return
+
+		case c := <-h.register:
+			conns, ok := h.clients[c.userID]
+			if !ok {
+				conns = make(map[*Client]struct{})
+				h.clients[c.userID] = conns
+			}
+			conns[c] = struct{}{}
+			metrics.RealtimeConnections.Inc()
+			h.log.Debug("hub: client registered", slog.Uint64("user_id", uint64(c.userID)))
+
+		case c := <-h.unregister:
+			h.remove(c)
+
+		case d := <-h.broadcast:
+			for _, userID := range d.userIDs {
+				for c := range h.clients[userID] {
+					select {
+					case c.send <- d.data:
+					default:
+						h.log.Warn("hub: send buffer full, dropping client",
+							slog.Uint64("user_id", uint64(c.userID)),
+						)
+						h.remove(c)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Publish delivers an event to every connection of the given users.
+func (h *Hub) Publish(userIDs []uint, eventType string, payload any) {
+	data, err := json.Marshal(Event{Type: eventType, Payload: payload})
+	if err != nil {
+		h.log.Error("hub: failed to encode event",
+			slog.String("type", eventType),
+			slog.Any("error", err),
+		)
+		return
+	}
+	select {
+	case h.broadcast <- delivery{userIDs: userIDs, data: data}:
+	case <-h.done:
+	}
+}
+
+// Shutdown stops Run and closes every connection with a "going away" close
+// frame after flushing what is already queued for it. It returns once all
+// connections are closed or ctx expires.
+func (h *Hub) Shutdown(ctx context.Context) error {
+	h.stopOnce.Do(func() { close(h.done) })
+
+	select {
+	case <-h.stopped:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	flushed := make(chan struct{})
+	go func() {
+		h.writers.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running reports whether Run is still serving clients.
+func (h *Hub) Running() bool {
+	select {
+	case <-h.done:
+		return false
+	default:
+		return true
+	}
+}
+
+// closeAll drops every client; their write pumps send the close frame.
+func (h *Hub) closeAll() {
+	n := 0
+	for _, conns := range h.clients {
+		for c := range conns {
+			c.goingAway = true
+			h.remove(c)
+			n++
+		}
+	}
+	h.log.Info("hub: stopped", slog.Int("clients", n))
+}
+
+func (h *Hub) remove(c *Client) {
+	conns, ok := h.clients[c.userID]
+	if !ok {
+		return
+	}
+	if _, ok := conns[c]; !ok {
+		return
+	}
+	delete(conns, c)
+	close(c.send)
+	metrics.RealtimeConnections.Dec()
+	if len(conns) == 0 {
+		delete(h.clients, c.userID)
+	}
+	h.log.Debug("hub: client unregistered", slog.Uint64("user_id", uint64(c.userID)))
+}
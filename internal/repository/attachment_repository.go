@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/DjMariarty/messenger/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type AttachmentRepository interface {
+	Create(ctx context.Context, attachment *models.Attachment) error
+	GetByID(ctx context.Context, id uint) (*models.Attachment, error)
+
+	ListPendingThumbnails(ctx context.Context, limit int) ([]uint, error)
+	SaveThumbnails(ctx context.Context, attachmentID uint, thumbnails []models.AttachmentThumbnail) error
+	SetThumbnailState(ctx context.Context, attachmentID uint, state string) error
+}
+
+type gormAttachmentRepository struct {
+	db  *gorm.DB
+	log *slog.Logger
+}
+
+func NewAttachmentRepository(db *gorm.DB, log *slog.Logger) AttachmentRepository {
+	return &gormAttachmentRepository{db: db, log: log}
+}
+
+func (r *gormAttachmentRepository) Create(ctx context.Context, attachment *models.Attachment) error {
+	if err := r.db.WithContext(ctx).Create(attachment).Error; err != nil {
+		r.log.ErrorContext(ctx, "create attachment failed", "chat_id", attachment.ChatID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *gormAttachmentRepository) GetByID(ctx context.Context, id uint) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := r.db.WithContext(ctx).
+		Preload("Thumbnails", orderThumbnails).
+		First(&attachment, id).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			r.log.ErrorContext(ctx, "get attachment failed", "attachment_id", id, "error", err)
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// ListPendingThumbnails returns the oldest images still waiting for
+// thumbnails.
+func (r *gormAttachmentRepository) ListPendingThumbnails(ctx context.Context, limit int) ([]uint, error) {
+	var ids []uint
+	err := r.db.WithContext(ctx).Model(&models.Attachment{}).
+		Where("thumbnail_state = ?", models.ThumbnailPending).
+		Order("id ASC").
+		Limit(limit).
+		Pluck("id", &ids).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "list pending thumbnails failed", "error", err)
+		return nil, err
+	}
+	return ids, nil
+}
+
+// SaveThumbnails records the thumbnails of an attachment and marks it ready.
+// Saving again replaces thumbnails of the same size.
+func (r *gormAttachmentRepository) SaveThumbnails(ctx context.Context, attachmentID uint, thumbnails []models.AttachmentThumbnail) error {
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if len(thumbnails) > 0 {
+			err := tx.Clauses(clause.OnConflict{
+				Columns:   []clause.Column{{Name: "attachment_id"}, {Name: "size"}},
+				DoUpdates: clause.AssignmentColumns([]string{"storage_key", "width", "height", "bytes"}),
+			}).Create(&thumbnails).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&models.Attachment{}).
+			Where("id = ?", attachmentID).
+			Update("thumbnail_state", models.ThumbnailReady).Error
+	})
+	if err != nil {
+		r.log.ErrorContext(ctx, "save thumbnails failed", "attachment_id", attachmentID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *gormAttachmentRepository) SetThumbnailState(ctx context.Context, attachmentID uint, state string) error {
+	err := r.db.WithContext(ctx).Model(&models.Attachment{}).
+		Where("id = ?", attachmentID).
+		Update("thumbnail_state", state).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "set thumbnail state failed", "attachment_id", attachmentID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func orderThumbnails(db *gorm.DB) *gorm.DB {
+	return db.Order("size ASC")
+}
@@ -1,19 +1,59 @@
 package repository
 
 import (
+	"context"
 	"errors"
+	"time"
 
 	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/jackc/pgx/v5/pgconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ChatRepository interface {
-	FindByUsers(user1ID, user2ID uint) (*models.Chat, error)
-	Create(chat *models.Chat) error
-	GetUserChats(userID uint) ([]models.Chat, error)
-	GetLastMessage(chatID uint) (*models.Message, error)
+	WithTx(tx *gorm.DB) ChatRepository
+	GetByID(ctx context.Context, id uint) (*models.Chat, error)
+	FindByUsers(ctx context.Context, user1ID, user2ID uint) (*models.Chat, error)
+	Create(ctx context.Context, chat *models.Chat) error
+	ListSummaries(ctx context.Context, userID uint, query ChatListQuery) ([]ChatSummary, error)
+	GetLastMessage(ctx context.Context, chatID, viewerID uint) (*models.Message, error)
+	Delete(ctx context.Context, chatID uint) error
+
+	AddMembers(ctx context.Context, members []models.ChatMember) error
+	RemoveMember(ctx context.Context, chatID, userID uint) error
+	SetMemberRole(ctx context.Context, chatID, userID uint, role string) error
+	LockMembers(ctx context.Context, chatID uint) ([]models.ChatMember, error)
+
+	MarkRead(ctx context.Context, chatID, userID, messageID uint) error
+}
+
+// ChatSummary is one row of a user's chat list: the chat, its newest message
+// visible to the user and the user's unread count.
+type ChatSummary struct {
+	ChatID               uint
+	Type                 string
+	Title                string
+	ActivityAt           time.Time
+	LastMessageID        *uint
+	LastMessageText      *string
+	LastMessageAt        *time.Time
+	LastMessageEditedAt  *time.Time
+	LastMessageRemovedAt *time.Time
+	UnreadCount          int64
+}
+
+// ChatListQuery selects one page of a chat list ordered by activity (the time
+// of the last message, or chat creation if it has none), newest first.
+type ChatListQuery struct {
+	Before *Cursor
+	Limit  int
 }
 
+// ErrDirectChatExists means a direct chat between the same two users was
+// created concurrently.
+var ErrDirectChatExists = errors.New("direct chat exists")
+
 type chatRepository struct {
 	db *gorm.DB
 }
@@ -22,34 +62,103 @@ func NewChatRepository(db *gorm.DB) ChatRepository {
 	return &chatRepository{db: db}
 }
 
-func (r *chatRepository) FindByUsers(user1ID, user2ID uint) (*models.Chat, error) {
+func (r *chatRepository) WithTx(tx *gorm.DB) ChatRepository {
+	return &chatRepository{db: tx}
+}
+
+// GetByID loads the chat together with its members.
+func (r *chatRepository) GetByID(ctx context.Context, id uint) (*models.Chat, error) {
 	var chat models.Chat
-	err := r.db.Where("user1_id = ? AND user2_id = ?", user1ID, user2ID).First(&chat).Error
+	if err := r.db.WithContext(ctx).Preload("Members").First(&chat, id).Error; err != nil {
+		return nil, err
+	}
+	return &chat, nil
+}
+
+func (r *chatRepository) FindByUsers(ctx context.Context, user1ID, user2ID uint) (*models.Chat, error) {
+	var chat models.Chat
+	err := r.db.WithContext(ctx).Preload("Members").
+		Where("type = ? AND user1_id = ? AND user2_id = ?", models.ChatTypeDirect, user1ID, user2ID).
+		First(&chat).Error
 	if err != nil {
 		return nil, err
 	}
 	return &chat, nil
 }
 
-func (r *chatRepository) Create(chat *models.Chat) error {
+// Create inserts the chat and its Members in one transaction.
+func (r *chatRepository) Create(ctx context.Context, chat *models.Chat) error {
 	if chat == nil {
 		return errors.New("nil chat")
 	}
-	return r.db.Create(chat).Error
+	err := r.db.WithContext(ctx).Create(chat).Error
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "idx_chats_direct_pair" {
+		return ErrDirectChatExists
+	}
+	return err
 }
 
-func (r *chatRepository) GetUserChats(userID uint) ([]models.Chat, error) {
-	var chats []models.Chat
-	err := r.db.Where("user1_id = ? OR user2_id = ?", userID, userID).Find(&chats).Error
-	if err != nil {
+// ListSummaries builds the chat list in a single query: a lateral join picks
+// the last visible message per chat and the database sorts and paginates.
+func (r *chatRepository) ListSummaries(ctx context.Context, userID uint, query ChatListQuery) ([]ChatSummary, error) {
+	sql := `
+		SELECT c.id AS chat_id, c.type, c.title,
+			COALESCE(lm.created_at, c.created_at) AS activity_at,
+			lm.id AS last_message_id,
+			lm.text AS last_message_text,
+			lm.created_at AS last_message_at,
+			lm.edited_at AS last_message_edited_at,
+			lm.removed_at AS last_message_removed_at,
+			(
+				SELECT COUNT(*) FROM messages m
+				WHERE m.chat_id = c.id
+					AND m.thread_root_id IS NULL
+					AND m.id > COALESCE(cm.last_read_message_id, 0)
+					AND m.created_at >= cm.joined_at
+					AND m.sender_id <> cm.user_id
+					AND m.deleted_at IS NULL
+					AND m.removed_at IS NULL
+					AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = cm.user_id)
+			) AS unread_count
+		FROM chat_members cm
+		JOIN chats c ON c.id = cm.chat_id AND c.deleted_at IS NULL
+		LEFT JOIN LATERAL (
+			SELECT m.id, m.text, m.created_at, m.edited_at, m.removed_at
+			FROM messages m
+			WHERE m.chat_id = c.id
+				AND m.thread_root_id IS NULL
+				AND m.deleted_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = cm.user_id)
+			ORDER BY m.created_at DESC, m.id DESC
+			LIMIT 1
+		) lm ON true
+		WHERE cm.user_id = @user`
+	args := map[string]any{"user": userID, "limit": query.Limit}
+	if query.Before != nil {
+		sql += ` AND (COALESCE(lm.created_at, c.created_at), c.id) < (@before_at, @before_id)`
+		args["before_at"] = query.Before.Time
+		args["before_id"] = query.Before.ID
+	}
+	sql += `
+		ORDER BY activity_at DESC, c.id DESC
+		LIMIT @limit`
+
+	var rows []ChatSummary
+	if err := r.db.WithContext(ctx).Raw(sql, args).Scan(&rows).Error; err != nil {
 		return nil, err
 	}
-	return chats, nil
+	return rows, nil
 }
 
-func (r *chatRepository) GetLastMessage(chatID uint) (*models.Message, error) {
+// GetLastMessage returns the newest message of the chat's main history that
+// viewerID has not deleted for themselves, or nil if there is none.
+func (r *chatRepository) GetLastMessage(ctx context.Context, chatID, viewerID uint) (*models.Message, error) {
 	var msg models.Message
-	err := r.db.Where("chat_id = ?", chatID).Order("created_at DESC").First(&msg).Error
+	err := r.db.WithContext(ctx).Where("chat_id = ? AND thread_root_id IS NULL", chatID).
+		Where(notHiddenFor, viewerID).
+		Order("created_at DESC, id DESC").
+		First(&msg).Error
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -58,3 +167,55 @@ func (r *chatRepository) GetLastMessage(chatID uint) (*models.Message, error) {
 	}
 	return &msg, nil
 }
+
+func (r *chatRepository) Delete(ctx context.Context, chatID uint) error {
+	return r.db.WithContext(ctx).Delete(&models.Chat{}, chatID).Error
+}
+
+func (r *chatRepository) AddMembers(ctx context.Context, members []models.ChatMember) error {
+	if len(members) == 0 {
+		return nil
+	}
+	return r.db.WithContext(ctx).Create(&members).Error
+}
+
+func (r *chatRepository) RemoveMember(ctx context.Context, chatID, userID uint) error {
+	return r.db.WithContext(ctx).Where("chat_id = ? AND user_id = ?", chatID, userID).Delete(&models.ChatMember{}).Error
+}
+
+func (r *chatRepository) SetMemberRole(ctx context.Context, chatID, userID uint, role string) error {
+	return r.db.WithContext(ctx).Model(&models.ChatMember{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Update("role", role).Error
+}
+
+// LockMembers returns the chat's members with their rows locked until the
+// transaction ends, so that role and member count checks made on them still
+// hold when the change is written. The chat row is locked first: that
+// serializes membership changes, inserts included, and the member query then
+// sees everything committed before. NO KEY UPDATE leaves new messages, which
+// only need a key share lock on the chat, unblocked.
+func (r *chatRepository) LockMembers(ctx context.Context, chatID uint) ([]models.ChatMember, error) {
+	db := r.db.WithContext(ctx)
+	var chat models.Chat
+	if err := db.Clauses(clause.Locking{Strength: "NO KEY UPDATE"}).Select("id").First(&chat, chatID).Error; err != nil {
+		return nil, err
+	}
+	var members []models.ChatMember
+	err := db.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+		Where("chat_id = ?", chatID).
+		Order("user_id").
+		Find(&members).Error
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// MarkRead moves the member's read pointer forward; it never moves it back.
+func (r *chatRepository) MarkRead(ctx context.Context, chatID, userID, messageID uint) error {
+	return r.db.WithContext(ctx).Model(&models.ChatMember{}).
+		Where("chat_id = ? AND user_id = ?", chatID, userID).
+		Where("last_read_message_id IS NULL OR last_read_message_id < ?", messageID).
+		Update("last_read_message_id", messageID).Error
+}
@@ -1,39 +1,137 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log/slog"
+	"time"
 
 	"github.com/DjMariarty/messenger/internal/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type MessageRepository interface {
-	Create(message *models.Message) error
-	GetMessagesByChatID(chatID uint) ([]models.Message, error)
+	WithTx(tx *gorm.DB) MessageRepository
+	Create(ctx context.Context, message *models.Message) error
+	CreateWithAttachments(ctx context.Context, message *models.Message, attachmentIDs []uint) error
+	GetByID(ctx context.Context, id uint) (*models.Message, error)
+	ListByChat(ctx context.Context, chatID uint, query MessageQuery) ([]models.Message, error)
+
+	ListThread(ctx context.Context, rootID uint, query MessageQuery) ([]models.Message, error)
+	ThreadUnreadCounts(ctx context.Context, viewerID uint, rootIDs []uint) (map[uint]int, error)
+	MarkThreadRead(ctx context.Context, rootID, userID, messageID uint) (uint, error)
+
+	Edit(ctx context.Context, message *models.Message, text string) error
+	RemoveForAll(ctx context.Context, message *models.Message) error
+	Hide(ctx context.Context, messageID, userID uint) error
+	ListEdits(ctx context.Context, messageID uint) ([]models.MessageEdit, error)
+
+	Search(ctx context.Context, query SearchQuery) ([]SearchHit, error)
+}
+
+// notHiddenFor filters out messages the viewer deleted for themselves.
+const notHiddenFor = "NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = messages.id AND h.user_id = ?)"
+
+// Cursor is a keyset position in a list ordered by (time, id).
+type Cursor struct {
+	Time time.Time
+	ID   uint
+}
+
+// MessageQuery selects one page of a chat history as seen by ViewerID. With
+// Before (or neither cursor) messages come newest first; with After they come
+// oldest first.
+type MessageQuery struct {
+	ViewerID uint
+	Before   *Cursor
+	After    *Cursor
+	Limit    int
+}
+
+// SearchQuery selects one page of full-text search results over the chats
+// ViewerID belongs to. ChatID, SenderID and the [From, Before) time range
+// narrow it down when set.
+type SearchQuery struct {
+	ViewerID uint
+	Text     string
+	ChatID   uint
+	SenderID uint
+	From     time.Time
+	Before   time.Time
+	After    *SearchCursor
+	Limit    int
 }
 
-var ErrMessageNil = errors.New("message nil")
+// SearchCursor is a keyset position in results ordered by (rank, time, id),
+// all descending.
+type SearchCursor struct {
+	Rank float32
+	Time time.Time
+	ID   uint
+}
+
+type SearchHit struct {
+	models.Message
+	Rank float32
+	// Snippet is the matching fragment with matches wrapped in
+	// HighlightStart and HighlightStop.
+	Snippet string
+}
+
+// Highlight markers are control characters, so that callers can escape the
+// snippet before turning them into markup.
+const (
+	HighlightStart = "\x02"
+	HighlightStop  = "\x03"
+)
+
+var (
+	ErrMessageNil = errors.New("message nil")
+	// ErrAttachmentsUnavailable means some attachment ids are unknown,
+	// already sent, or were uploaded by someone else or into another chat.
+	ErrAttachmentsUnavailable = errors.New("attachments unavailable")
+	// ErrMessageRemoved means the message was deleted for everyone while
+	// it was being changed.
+	ErrMessageRemoved = errors.New("message removed")
+)
 
 type gormMessageRepository struct {
-	db  *gorm.DB
-	log *slog.Logger
+	db             *gorm.DB
+	log            *slog.Logger
+	searchLanguage string
 }
 
-func NewMessageRepository(db *gorm.DB, log *slog.Logger) MessageRepository {
-	return &gormMessageRepository{db: db, log: log}
+// NewMessageRepository indexes new messages for search with searchLanguage.
+func NewMessageRepository(db *gorm.DB, log *slog.Logger, searchLanguage string) MessageRepository {
+	return &gormMessageRepository{db: db, log: log, searchLanguage: searchLanguage}
 }
 
-func (r *gormMessageRepository) Create(message *models.Message) error {
+func (r *gormMessageRepository) WithTx(tx *gorm.DB) MessageRepository {
+	return &gormMessageRepository{db: tx, log: r.log, searchLanguage: r.searchLanguage}
+}
+
+func (r *gormMessageRepository) Create(ctx context.Context, message *models.Message) error {
 	if message == nil {
-		r.log.Error("create: message is nil")
+		r.log.ErrorContext(ctx, "create: message is nil")
 		return ErrMessageNil
 	}
 
-	r.log.Debug("creating message", "chat_id", message.ChatID, "sender_id", message.SenderID)
+	r.log.DebugContext(ctx, "creating message", "chat_id", message.ChatID, "sender_id", message.SenderID)
+
+	if message.SearchLanguage == "" {
+		message.SearchLanguage = r.searchLanguage
+	}
 
-	if err := r.db.Create(message).Error; err != nil {
-		r.log.Error("create failed", "chat_id", message.ChatID, "sender_id", message.SenderID, "error", err)
+	// ReplyTo may hold the quoted message, which is not saved again.
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(message).Error; err != nil {
+			return err
+		}
+		return countThreadReply(tx, message)
+	})
+	if err != nil {
+		r.log.ErrorContext(ctx, "create failed", "chat_id", message.ChatID, "sender_id", message.SenderID, "error", err)
 		return err
 	}
 
@@ -41,14 +139,442 @@ func (r *gormMessageRepository) Create(message *models.Message) error {
 
 }
 
-func (r *gormMessageRepository) GetMessagesByChatID(userID uint) ([]models.Message, error) {
-	r.log.Debug("fetch messages by chat", "user_id", userID)
+// countThreadReply updates the summary of the thread a new message joins.
+func countThreadReply(tx *gorm.DB, message *models.Message) error {
+	if message.ThreadRootID == nil {
+		return nil
+	}
+	return tx.Model(&models.Message{}).
+		Where("id = ?", *message.ThreadRootID).
+		UpdateColumns(map[string]any{
+			"reply_count":   gorm.Expr("reply_count + 1"),
+			"last_reply_at": gorm.Expr("GREATEST(last_reply_at, ?)", message.CreatedAt),
+		}).Error
+}
+
+// recountThread rebuilds the summary of a thread after a reply is removed.
+func recountThread(tx *gorm.DB, rootID uint) error {
+	return tx.Exec(`
+		UPDATE messages SET
+			reply_count = (SELECT COUNT(*) FROM messages r
+				WHERE r.thread_root_id = @root AND r.removed_at IS NULL AND r.deleted_at IS NULL),
+			last_reply_at = (SELECT MAX(r.created_at) FROM messages r
+				WHERE r.thread_root_id = @root AND r.removed_at IS NULL AND r.deleted_at IS NULL)
+		WHERE id = @root
+	`, map[string]any{"root": rootID}).Error
+}
+
+// CreateWithAttachments creates message and binds the given unsent
+// attachments of its sender and chat to it, all or nothing.
+func (r *gormMessageRepository) CreateWithAttachments(ctx context.Context, message *models.Message, attachmentIDs []uint) error {
+	if message == nil {
+		r.log.ErrorContext(ctx, "create: message is nil")
+		return ErrMessageNil
+	}
+	if message.SearchLanguage == "" {
+		message.SearchLanguage = r.searchLanguage
+	}
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(message).Error; err != nil {
+			return err
+		}
+		if err := countThreadReply(tx, message); err != nil {
+			return err
+		}
+		res := tx.Model(&models.Attachment{}).
+			Where("id IN ? AND chat_id = ? AND uploader_id = ? AND message_id IS NULL", attachmentIDs, message.ChatID, message.SenderID).
+			Update("message_id", message.ID)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected != int64(len(attachmentIDs)) {
+			return ErrAttachmentsUnavailable
+		}
+		return tx.Where("message_id = ?", message.ID).
+			Preload("Thumbnails", orderThumbnails).
+			Order("id ASC").
+			Find(&message.Attachments).Error
+	})
+	if err != nil {
+		if !errors.Is(err, ErrAttachmentsUnavailable) {
+			r.log.ErrorContext(ctx, "create with attachments failed", "chat_id", message.ChatID, "sender_id", message.SenderID, "error", err)
+		}
+		return err
+	}
+	return nil
+}
+
+// ListByChat pages through the main history of a chat; thread replies are
+// listed by ListThread instead.
+func (r *gormMessageRepository) ListByChat(ctx context.Context, chatID uint, query MessageQuery) ([]models.Message, error) {
+	r.log.DebugContext(ctx, "fetch messages by chat", "chat_id", chatID, "limit", query.Limit)
+
+	q := r.db.WithContext(ctx).Model(&models.Message{}).
+		Where("chat_id = ? AND thread_root_id IS NULL", chatID)
+	messages, err := r.list(q, query)
+	if err != nil {
+		r.log.ErrorContext(ctx, "fetch messages failed", "chat_id", chatID, "error", err)
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ListThread pages through the replies in the thread of rootID.
+func (r *gormMessageRepository) ListThread(ctx context.Context, rootID uint, query MessageQuery) ([]models.Message, error) {
+	r.log.DebugContext(ctx, "fetch thread", "root_id", rootID, "limit", query.Limit)
+
+	q := r.db.WithContext(ctx).Model(&models.Message{}).
+		Where("thread_root_id = ?", rootID)
+	messages, err := r.list(q, query)
+	if err != nil {
+		r.log.ErrorContext(ctx, "fetch thread failed", "root_id", rootID, "error", err)
+		return nil, err
+	}
+	return messages, nil
+}
+
+// list applies the paging of query, as seen by its viewer, to q.
+func (r *gormMessageRepository) list(q *gorm.DB, query MessageQuery) ([]models.Message, error) {
+	q = q.Preload("Attachments", orderAttachments).
+		Preload("Attachments.Thumbnails", orderThumbnails).
+		Preload("ReplyTo", selectPreview).
+		Where(notHiddenFor, query.ViewerID)
+	switch {
+	case query.After != nil:
+		q = q.Where("(created_at, id) > (?, ?)", query.After.Time, query.After.ID).
+			Order("created_at ASC, id ASC")
+	case query.Before != nil:
+		q = q.Where("(created_at, id) < (?, ?)", query.Before.Time, query.Before.ID).
+			Order("created_at DESC, id DESC")
+	default:
+		q = q.Order("created_at DESC, id DESC")
+	}
 
 	var messages []models.Message
-	err := r.db.Model(&models.Message{}).Where("user_id = ?", userID).Order("created_at desc").Find(&messages).Error
+	if err := q.Limit(query.Limit).Find(&messages).Error; err != nil {
+		return nil, err
+	}
+	return messages, nil
+}
+
+// ThreadUnreadCounts counts, for each root in rootIDs, the thread replies of
+// others that viewerID has not read yet. As for the chat list, replies from
+// before the viewer joined the chat do not count. Roots without any are left
+// out.
+func (r *gormMessageRepository) ThreadUnreadCounts(ctx context.Context, viewerID uint, rootIDs []uint) (map[uint]int, error) {
+	counts := make(map[uint]int)
+	if len(rootIDs) == 0 {
+		return counts, nil
+	}
+
+	var rows []struct {
+		RootID uint
+		Unread int
+	}
+	err := r.db.WithContext(ctx).Raw(`
+		SELECT m.thread_root_id AS root_id, COUNT(*) AS unread
+		FROM messages m
+		JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = @viewer
+		LEFT JOIN thread_reads tr ON tr.root_message_id = m.thread_root_id AND tr.user_id = @viewer
+		WHERE m.thread_root_id IN @roots
+			AND m.id > COALESCE(tr.last_read_message_id, 0)
+			AND m.created_at >= cm.joined_at
+			AND m.sender_id <> @viewer
+			AND m.deleted_at IS NULL
+			AND m.removed_at IS NULL
+			AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = @viewer)
+		GROUP BY m.thread_root_id
+	`, map[string]any{"viewer": viewerID, "roots": rootIDs}).Scan(&rows).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "count thread unread failed", "viewer_id", viewerID, "error", err)
+		return nil, err
+	}
+	for _, row := range rows {
+		counts[row.RootID] = row.Unread
+	}
+	return counts, nil
+}
+
+// MarkThreadRead moves the user's read pointer in a thread forward, never
+// back, and returns where it ends up.
+func (r *gormMessageRepository) MarkThreadRead(ctx context.Context, rootID, userID, messageID uint) (uint, error) {
+	var pointer uint
+	err := r.db.WithContext(ctx).Raw(`
+		INSERT INTO thread_reads (root_message_id, user_id, last_read_message_id)
+		VALUES (@root, @user, @message)
+		ON CONFLICT (root_message_id, user_id) DO UPDATE
+			SET last_read_message_id = GREATEST(thread_reads.last_read_message_id, EXCLUDED.last_read_message_id)
+		RETURNING last_read_message_id
+	`, map[string]any{"root": rootID, "user": userID, "message": messageID}).Scan(&pointer).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "mark thread read failed", "root_id", rootID, "user_id", userID, "error", err)
+		return 0, err
+	}
+	return pointer, nil
+}
+
+func (r *gormMessageRepository) GetByID(ctx context.Context, id uint) (*models.Message, error) {
+	var message models.Message
+	err := r.db.WithContext(ctx).
+		Preload("Attachments", orderAttachments).
+		Preload("Attachments.Thumbnails", orderThumbnails).
+		Preload("ReplyTo", selectPreview).
+		First(&message, id).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			r.log.ErrorContext(ctx, "get message failed", "message_id", id, "error", err)
+		}
+		return nil, err
+	}
+	return &message, nil
+}
+
+// Edit replaces the text of message and keeps the previous text as a revision.
+// The previous text is read from the locked row rather than from message, so
+// that of two concurrent edits the second records the text of the first.
+func (r *gormMessageRepository) Edit(ctx context.Context, message *models.Message, text string) error {
+	now := time.Now()
+
+	var current models.Message
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Select("id", "text", "edited_at", "removed_at").
+			First(&current, message.ID).Error
+		if err != nil {
+			return err
+		}
+		if current.RemovedAt != nil {
+			return ErrMessageRemoved
+		}
+		if current.Text == text {
+			return nil
+		}
+
+		edit := models.MessageEdit{MessageID: message.ID, Text: current.Text, CreatedAt: now}
+		if err := tx.Create(&edit).Error; err != nil {
+			return err
+		}
+		current.EditedAt = &now
+		return tx.Model(message).Updates(map[string]any{"text": text, "edited_at": now}).Error
+	})
+	if err != nil {
+		r.log.ErrorContext(ctx, "edit message failed", "message_id", message.ID, "error", err)
+		return err
+	}
+
+	message.Text = text
+	message.EditedAt = current.EditedAt
+	return nil
+}
+
+// RemoveForAll turns message into a tombstone and drops its revisions and
+// attachment records. Deleting the attachment contents is up to the caller.
+// The row is locked first, like in Edit, so that a concurrent edit cannot
+// add a revision after they are dropped; of two concurrent removals the
+// second fails with ErrMessageRemoved.
+func (r *gormMessageRepository) RemoveForAll(ctx context.Context, message *models.Message) error {
+	now := time.Now()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var current models.Message
+		err := tx.Clauses(clause.Locking{Strength: clause.LockingStrengthUpdate}).
+			Select("id", "removed_at").
+			First(&current, message.ID).Error
+		if err != nil {
+			return err
+		}
+		if current.RemovedAt != nil {
+			return ErrMessageRemoved
+		}
+
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.MessageEdit{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("message_id = ?", message.ID).Delete(&models.Attachment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(message).Updates(map[string]any{"text": "", "removed_at": now}).Error; err != nil {
+			return err
+		}
+		if message.ThreadRootID != nil {
+			return recountThread(tx, *message.ThreadRootID)
+		}
+		return nil
+	})
 	if err != nil {
-		r.log.Error("fetch messages failed", "user_id", userID, "error", err)
+		if !errors.Is(err, ErrMessageRemoved) {
+			r.log.ErrorContext(ctx, "remove message failed", "message_id", message.ID, "error", err)
+		}
+		return err
+	}
+
+	message.Text = ""
+	message.RemovedAt = &now
+	message.Attachments = nil
+	return nil
+}
+
+func (r *gormMessageRepository) Hide(ctx context.Context, messageID, userID uint) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.HiddenMessage{MessageID: messageID, UserID: userID}).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "hide message failed", "message_id", messageID, "user_id", userID, "error", err)
+		return err
+	}
+	return nil
+}
+
+func (r *gormMessageRepository) ListEdits(ctx context.Context, messageID uint) ([]models.MessageEdit, error) {
+	var edits []models.MessageEdit
+	err := r.db.WithContext(ctx).Where("message_id = ?", messageID).Order("created_at ASC, id ASC").Find(&edits).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "list message edits failed", "message_id", messageID, "error", err)
 		return nil, err
 	}
-	return messages, err
+	return edits, nil
+}
+
+// Search matches the query against every search language, so a message is
+// found whichever configuration it was indexed with. Snippets are built only
+// for the page being returned.
+func (r *gormMessageRepository) Search(ctx context.Context, query SearchQuery) ([]SearchHit, error) {
+	args := map[string]any{
+		"viewer":   query.ViewerID,
+		"text":     query.Text,
+		"limit":    query.Limit,
+		"headline": `StartSel="` + HighlightStart + `", StopSel="` + HighlightStop + `", MaxFragments=2, MinWords=5, MaxWords=20, FragmentDelimiter=" … "`,
+	}
+	filters := ""
+	if query.ChatID != 0 {
+		filters += " AND m.chat_id = @chat"
+		args["chat"] = query.ChatID
+	}
+	if query.SenderID != 0 {
+		filters += " AND m.sender_id = @sender"
+		args["sender"] = query.SenderID
+	}
+	if !query.From.IsZero() {
+		filters += " AND m.created_at >= @from"
+		args["from"] = query.From
+	}
+	if !query.Before.IsZero() {
+		filters += " AND m.created_at < @before"
+		args["before"] = query.Before
+	}
+	if query.After != nil {
+		filters += " AND (ts_rank_cd(m.search_vector, q.query), m.created_at, m.id) < (@rank::real, @time, @id)"
+		args["rank"] = query.After.Rank
+		args["time"] = query.After.Time
+		args["id"] = query.After.ID
+	}
+
+	var hits []SearchHit
+	err := r.db.WithContext(ctx).Raw(`
+		WITH q AS (
+			SELECT websearch_to_tsquery('russian', @text)
+				|| websearch_to_tsquery('english', @text)
+				|| websearch_to_tsquery('simple', @text) AS query
+		), hits AS (
+			SELECT m.id, m.created_at, m.updated_at, m.chat_id, m.sender_id, m.type, m.text,
+				m.reply_to_message_id, m.thread_root_id, m.edited_at, m.search_language,
+				m.reply_count, m.last_reply_at,
+				ts_rank_cd(m.search_vector, q.query) AS rank
+			FROM messages m
+			CROSS JOIN q
+			JOIN chat_members cm ON cm.chat_id = m.chat_id AND cm.user_id = @viewer
+			WHERE m.search_vector @@ q.query
+				AND m.deleted_at IS NULL
+				AND m.removed_at IS NULL
+				AND NOT EXISTS (SELECT 1 FROM hidden_messages h WHERE h.message_id = m.id AND h.user_id = @viewer)`+filters+`
+			ORDER BY rank DESC, m.created_at DESC, m.id DESC
+			LIMIT @limit
+		)
+		SELECT hits.*, ts_headline(hits.search_language, hits.text, q.query, @headline) AS snippet
+		FROM hits
+		CROSS JOIN q
+		ORDER BY hits.rank DESC, hits.created_at DESC, hits.id DESC
+	`, args).Scan(&hits).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "search messages failed", "viewer_id", query.ViewerID, "chat_id", query.ChatID, "error", err)
+		return nil, err
+	}
+	if err := r.loadAttachments(ctx, hits); err != nil {
+		r.log.ErrorContext(ctx, "load search attachments failed", "viewer_id", query.ViewerID, "error", err)
+		return nil, err
+	}
+	if err := r.loadReplyPreviews(ctx, hits); err != nil {
+		r.log.ErrorContext(ctx, "load search reply previews failed", "viewer_id", query.ViewerID, "error", err)
+		return nil, err
+	}
+	return hits, nil
+}
+
+// loadAttachments sets Attachments on the hits, ordered and with thumbnails
+// as GetByID loads them, in one query per table for the whole page.
+func (r *gormMessageRepository) loadAttachments(ctx context.Context, hits []SearchHit) error {
+	if len(hits) == 0 {
+		return nil
+	}
+	ids := make([]uint, len(hits))
+	for i, h := range hits {
+		ids[i] = h.ID
+	}
+
+	var attachments []models.Attachment
+	err := r.db.WithContext(ctx).
+		Scopes(orderAttachments).
+		Preload("Thumbnails", orderThumbnails).
+		Where("message_id IN ?", ids).
+		Find(&attachments).Error
+	if err != nil {
+		return err
+	}
+	byMessage := make(map[uint][]models.Attachment, len(hits))
+	for _, a := range attachments {
+		byMessage[*a.MessageID] = append(byMessage[*a.MessageID], a)
+	}
+	for i := range hits {
+		hits[i].Attachments = byMessage[hits[i].ID]
+	}
+	return nil
+}
+
+// loadReplyPreviews sets ReplyTo on the hits that quote a message, as
+// Preload("ReplyTo", selectPreview) does for listed messages, in one query
+// for the whole page. Quoted messages that are gone stay nil.
+func (r *gormMessageRepository) loadReplyPreviews(ctx context.Context, hits []SearchHit) error {
+	var ids []uint
+	for _, h := range hits {
+		if h.ReplyToMessageID != nil {
+			ids = append(ids, *h.ReplyToMessageID)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var quoted []models.Message
+	if err := r.db.WithContext(ctx).Scopes(selectPreview).Where("id IN ?", ids).Find(&quoted).Error; err != nil {
+		return err
+	}
+	byID := make(map[uint]*models.Message, len(quoted))
+	for i := range quoted {
+		byID[quoted[i].ID] = &quoted[i]
+	}
+	for i := range hits {
+		if id := hits[i].ReplyToMessageID; id != nil {
+			hits[i].ReplyTo = byID[*id]
+		}
+	}
+	return nil
+}
+
+func orderAttachments(db *gorm.DB) *gorm.DB {
+	return db.Order("id ASC")
+}
+
+// selectPreview loads just what a quoted message preview shows.
+func selectPreview(db *gorm.DB) *gorm.DB {
+	return db.Select("id", "sender_id", "type", "text", "removed_at")
 }
@@ -0,0 +1,254 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/DjMariarty/messenger/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TokenRepository interface {
+	WithTx(tx *gorm.DB) TokenRepository
+
+	CreateSession(ctx context.Context, session *models.Session) error
+	GetSession(ctx context.Context, id uint) (*models.Session, error)
+	ListActiveSessions(ctx context.Context, userID uint) ([]models.Session, error)
+	TouchSession(ctx context.Context, id uint, userAgent, ip string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id uint) error
+	RevokeOtherSessions(ctx context.Context, userID, keepID uint) error
+
+	CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error
+	GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error)
+	MarkRefreshTokenUsed(ctx context.Context, id uint) (bool, error)
+
+	RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string, sessionID uint) (bool, error)
+}
+
+const (
+	// Sessions seen within this window are not touched again on every
+	// request.
+	lastSeenResolution = time.Minute
+	// revokedTokensPruneBatch bounds how many expired revocations one
+	// logout deletes, so that a backlog is worked off gradually.
+	revokedTokensPruneBatch = 1000
+)
+
+type gormTokenRepository struct {
+	db  *gorm.DB
+	log *slog.Logger
+}
+
+func NewTokenRepository(db *gorm.DB, log *slog.Logger) TokenRepository {
+	return &gormTokenRepository{db: db, log: log}
+}
+
+func (r *gormTokenRepository) WithTx(tx *gorm.DB) TokenRepository {
+	return &gormTokenRepository{db: tx, log: r.log}
+}
+
+func (r *gormTokenRepository) CreateSession(ctx context.Context, session *models.Session) error {
+	if err := r.db.WithContext(ctx).Create(session).Error; err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to create session",
+			slog.Uint64("user_id", uint64(session.UserID)),
+			slog.Any("error", err),
+		)
+		return err
+	}
+	return nil
+}
+
+func (r *gormTokenRepository) GetSession(ctx context.Context, id uint) (*models.Session, error) {
+	var session models.Session
+	if err := r.db.WithContext(ctx).First(&session, id).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			r.log.ErrorContext(ctx, "token repository: failed to get session",
+				slog.Uint64("session_id", uint64(id)),
+				slog.Any("error", err),
+			)
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *gormTokenRepository) ListActiveSessions(ctx context.Context, userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := r.db.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to list sessions",
+			slog.Uint64("user_id", uint64(userID)),
+			slog.Any("error", err),
+		)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// TouchSession records activity on a refresh: new client details, last seen
+// time and the expiry of the newest refresh token.
+func (r *gormTokenRepository) TouchSession(ctx context.Context, id uint, userAgent, ip string, expiresAt time.Time) error {
+	err := r.db.WithContext(ctx).Model(&models.Session{}).Where("id = ?", id).Updates(map[string]any{
+		"user_agent":   userAgent,
+		"ip":           ip,
+		"last_seen_at": time.Now(),
+		"expires_at":   expiresAt,
+	}).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to touch session",
+			slog.Uint64("session_id", uint64(id)),
+			slog.Any("error", err),
+		)
+		return err
+	}
+	return nil
+}
+
+// RevokeSession ends a session and every refresh token issued in it.
+func (r *gormTokenRepository) RevokeSession(ctx context.Context, id uint) error {
+	now := time.Now()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Session{}).
+			Where("id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("session_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to revoke session",
+			slog.Uint64("session_id", uint64(id)),
+			slog.Any("error", err),
+		)
+		return err
+	}
+	return nil
+}
+
+func (r *gormTokenRepository) RevokeOtherSessions(ctx context.Context, userID, keepID uint) error {
+	now := time.Now()
+
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Session{}).
+			Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+			Update("revoked_at", now).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.RefreshToken{}).
+			Where("user_id = ? AND session_id <> ? AND revoked_at IS NULL", userID, keepID).
+			Update("revoked_at", now).Error
+	})
+	if err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to revoke other sessions",
+			slog.Uint64("user_id", uint64(userID)),
+			slog.Any("error", err),
+		)
+		return err
+	}
+	return nil
+}
+
+func (r *gormTokenRepository) CreateRefreshToken(ctx context.Context, token *models.RefreshToken) error {
+	if err := r.db.WithContext(ctx).Create(token).Error; err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to create refresh token",
+			slog.Uint64("user_id", uint64(token.UserID)),
+			slog.Any("error", err),
+		)
+		return err
+	}
+	return nil
+}
+
+func (r *gormTokenRepository) GetRefreshTokenByHash(ctx context.Context, hash string) (*models.RefreshToken, error) {
+	var token models.RefreshToken
+	if err := r.db.WithContext(ctx).Where("token_hash = ?", hash).First(&token).Error; err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			r.log.ErrorContext(ctx, "token repository: failed to get refresh token", slog.Any("error", err))
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed flags the token as rotated. It reports false if the
+// token was already used or revoked, which means it is being replayed.
+func (r *gormTokenRepository) MarkRefreshTokenUsed(ctx context.Context, id uint) (bool, error) {
+	res := r.db.WithContext(ctx).Model(&models.RefreshToken{}).
+		Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", id).
+		Update("used_at", time.Now())
+	if res.Error != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to mark refresh token used",
+			slog.Uint64("token_id", uint64(id)),
+			slog.Any("error", res.Error),
+		)
+		return false, res.Error
+	}
+	return res.RowsAffected == 1, nil
+}
+
+// RevokeAccessToken lists jti until expiresAt. It also prunes entries whose
+// tokens have expired since, which would be rejected anyway.
+func (r *gormTokenRepository) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	err := r.db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&models.RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to revoke access token",
+			slog.String("jti", jti),
+			slog.Any("error", err),
+		)
+		return err
+	}
+
+	res := r.db.WithContext(ctx).Exec(`
+		DELETE FROM revoked_tokens WHERE jti IN (
+			SELECT jti FROM revoked_tokens WHERE expires_at < ? LIMIT ?
+		)`, time.Now(), revokedTokensPruneBatch)
+	if res.Error != nil {
+		// The list only grows until the next logout succeeds in pruning it.
+		r.log.WarnContext(ctx, "token repository: failed to prune revoked tokens", slog.Any("error", res.Error))
+	} else if res.RowsAffected > 0 {
+		r.log.DebugContext(ctx, "token repository: pruned revoked tokens", slog.Int64("count", res.RowsAffected))
+	}
+	return nil
+}
+
+// IsRevoked checks the jti revocation list and the session in one round
+// trip, bumping the session's last_seen_at when it is stale.
+func (r *gormTokenRepository) IsRevoked(ctx context.Context, jti string, sessionID uint) (bool, error) {
+	var revoked bool
+	err := r.db.WithContext(ctx).Raw(`
+		WITH touched AS (
+			UPDATE sessions SET last_seen_at = @now
+			WHERE id = @sid AND revoked_at IS NULL AND last_seen_at < @stale
+			RETURNING id
+		)
+		SELECT EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = @jti)
+			OR EXISTS (SELECT 1 FROM sessions WHERE id = @sid AND revoked_at IS NOT NULL)
+	`, map[string]any{
+		"now":   time.Now(),
+		"stale": time.Now().Add(-lastSeenResolution),
+		"sid":   sessionID,
+		"jti":   jti,
+	}).Scan(&revoked).Error
+	if err != nil {
+		r.log.ErrorContext(ctx, "token repository: failed to check revocation",
+			slog.String("jti", jti),
+			slog.Uint64("session_id", uint64(sessionID)),
+			slog.Any("error", err),
+		)
+		return false, err
+	}
+	return revoked, nil
+}
@@ -1,6 +1,7 @@
 package repository
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 
@@ -9,9 +10,11 @@ import (
 )
 
 type UserRepository interface {
-	Create(user *models.User) error
-	GetByID(id uint) (*models.User, error)
-	GetByEmail(email string) (*models.User, error)
+	Create(ctx context.Context, user *models.User) error
+	GetByID(ctx context.Context, id uint) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	GetByIDs(ctx context.Context, ids []uint) ([]models.User, error)
+	UpdateLocale(ctx context.Context, id uint, locale string) error
 }
 
 type gormUserRepository struct {
@@ -26,16 +29,16 @@ func NewUserRepository(db *gorm.DB, log *slog.Logger) UserRepository {
 	}
 }
 
-func (r *gormUserRepository) Create(user *models.User) error {
+func (r *gormUserRepository) Create(ctx context.Context, user *models.User) error {
 	if user == nil {
-		r.log.Warn(
+		r.log.WarnContext(ctx,
 			"user repository: create called with nil user",
 		)
-		return errors.New("пустой пользователь")
+		return errors.New("nil user")
 	}
 
-	if err := r.db.Create(user).Error; err != nil {
-		r.log.Error(
+	if err := r.db.WithContext(ctx).Create(user).Error; err != nil {
+		r.log.ErrorContext(ctx,
 			"user repository: failed to create user",
 			slog.String("email", user.Email),
 			slog.Any("error", err),
@@ -46,17 +49,17 @@ func (r *gormUserRepository) Create(user *models.User) error {
 	return nil
 }
 
-func (r *gormUserRepository) GetByID(id uint) (*models.User, error) {
+func (r *gormUserRepository) GetByID(ctx context.Context, id uint) (*models.User, error) {
 	var user models.User
 
-	if err := r.db.First(&user, id).Error; err != nil {
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			r.log.Warn(
+			r.log.WarnContext(ctx,
 				"user repository: user not found by id",
 				slog.Uint64("user_id", uint64(id)),
 			)
 		} else {
-			r.log.Error(
+			r.log.ErrorContext(ctx,
 				"user repository: failed to get user by id",
 				slog.Uint64("user_id", uint64(id)),
 				slog.Any("error", err),
@@ -68,17 +71,17 @@ func (r *gormUserRepository) GetByID(id uint) (*models.User, error) {
 	return &user, nil
 }
 
-func (r *gormUserRepository) GetByEmail(email string) (*models.User, error) {
+func (r *gormUserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	var user models.User
 
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			r.log.Warn(
+			r.log.WarnContext(ctx,
 				"user repository: user not found by email",
 				slog.String("email", email),
 			)
 		} else {
-			r.log.Error(
+			r.log.ErrorContext(ctx,
 				"user repository: failed to get user by email",
 				slog.String("email", email),
 				slog.Any("error", err),
@@ -89,3 +92,35 @@ func (r *gormUserRepository) GetByEmail(email string) (*models.User, error) {
 
 	return &user, nil
 }
+
+func (r *gormUserRepository) GetByIDs(ctx context.Context, ids []uint) ([]models.User, error) {
+	var users []models.User
+
+	if err := r.db.WithContext(ctx).Where("id IN ?", ids).Find(&users).Error; err != nil {
+		r.log.ErrorContext(ctx,
+			"user repository: failed to get users by ids",
+			slog.Int("ids", len(ids)),
+			slog.Any("error", err),
+		)
+		return nil, err
+	}
+
+	return users, nil
+}
+
+func (r *gormUserRepository) UpdateLocale(ctx context.Context, id uint, locale string) error {
+	res := r.db.WithContext(ctx).Model(&models.User{}).Where("id = ?", id).Update("locale", locale)
+	if res.Error != nil {
+		r.log.ErrorContext(ctx,
+			"user repository: failed to update locale",
+			slog.Uint64("user_id", uint64(id)),
+			slog.Any("error", res.Error),
+		)
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,363 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/auth"
+	"github.com/DjMariarty/messenger/internal/config"
+	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/media"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/storage"
+	"github.com/DjMariarty/messenger/internal/tracing"
+	_ "golang.org/x/image/webp"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAttachmentNotFound     = apperr.New(apperr.NotFound, "attachment_not_found", "attachment not found")
+	ErrAttachmentTooLarge     = apperr.New(apperr.TooLarge, "attachment_too_large", "attachment is too large")
+	ErrAttachmentType         = apperr.New(apperr.UnsupportedMediaType, "attachment_type_not_allowed", "attachment type is not allowed")
+	ErrEmptyAttachment        = apperr.New(apperr.Invalid, "empty_attachment", "attachment is empty")
+	ErrInvalidImage           = apperr.New(apperr.Invalid, "invalid_image", "image file is damaged")
+	ErrThumbnailNotFound      = apperr.New(apperr.NotFound, "thumbnail_not_found", "thumbnail not found")
+	ErrAttachmentsUnavailable = apperr.New(apperr.Invalid, "attachments_unavailable", "attachments must be your unsent uploads to this chat")
+	ErrTooManyAttachments     = apperr.New(apperr.Invalid, "too_many_attachments", "too many attachments")
+)
+
+const (
+	maxAttachmentsPerMessage = 10
+	maxFileNameLength        = 255
+	// sniffLen is how much http.DetectContentType looks at.
+	sniffLen = 512
+)
+
+// Upload is a file received from a client. Content must be seekable: it is
+// read once to sniff its type and dimensions and once more to store it.
+type Upload struct {
+	FileName string
+	Size     int64
+	Content  io.ReadSeeker
+}
+
+// AttachmentService stores uploaded files and serves them to chat members.
+type AttachmentService interface {
+	Upload(ctx context.Context, userID, chatID uint, upload Upload) (*dto.AttachmentResponse, error)
+	Get(ctx context.Context, userID, id uint) (*dto.AttachmentResponse, error)
+	// Open returns the attachment with its content; the caller closes it.
+	Open(ctx context.Context, userID, id uint) (*models.Attachment, io.ReadSeekCloser, error)
+	// OpenThumbnail is Open for the thumbnail made for size.
+	OpenThumbnail(ctx context.Context, userID, id uint, size int) (*models.AttachmentThumbnail, io.ReadSeekCloser, error)
+}
+
+// thumbnailTypes are the image formats we can decode to make thumbnails.
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+type attachmentService struct {
+	attachments repository.AttachmentRepository
+	access      ChatAccess
+	blobs       storage.Blob
+	thumbnails  ThumbnailQueue
+	cfg         config.AttachmentConfig
+	log         *slog.Logger
+}
+
+func NewAttachmentService(
+	attachments repository.AttachmentRepository,
+	access ChatAccess,
+	blobs storage.Blob,
+	thumbnails ThumbnailQueue,
+	cfg config.AttachmentConfig,
+	log *slog.Logger,
+) AttachmentService {
+	return &attachmentService{attachments: attachments, access: access, blobs: blobs, thumbnails: thumbnails, cfg: cfg, log: log}
+}
+
+func (s *attachmentService) Upload(ctx context.Context, userID, chatID uint, upload Upload) (*dto.AttachmentResponse, error) {
+	ctx, span := tracing.Start(ctx, "AttachmentService.Upload")
+	defer span.End()
+
+	if upload.Size <= 0 {
+		return nil, ErrEmptyAttachment
+	}
+	if upload.Size > s.cfg.MaxSize {
+		return nil, ErrAttachmentTooLarge.WithDetails(map[string]any{"max_size": s.cfg.MaxSize})
+	}
+	if _, err := s.access.RequireMember(ctx, chatID, userID); err != nil {
+		return nil, err
+	}
+
+	mimeType, err := sniff(upload.Content)
+	if err != nil {
+		return nil, err
+	}
+	if !s.allowed(mimeType) {
+		return nil, ErrAttachmentType.WithDetails(map[string]any{"mime_type": mimeType})
+	}
+
+	att := &models.Attachment{
+		ChatID:     chatID,
+		UploaderID: userID,
+		StorageKey: fmt.Sprintf("chats/%d/%s", chatID, auth.NewID()),
+		FileName:   cleanFileName(upload.FileName),
+		MimeType:   mimeType,
+		Size:       upload.Size,
+	}
+	if strings.HasPrefix(mimeType, "image/") {
+		if _, err := upload.Content.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		// Formats we cannot decode are still accepted, just without size
+		// or thumbnails.
+		if cfg, _, err := image.DecodeConfig(upload.Content); err == nil {
+			att.Width, att.Height = &cfg.Width, &cfg.Height
+			if thumbnailTypes[mimeType] {
+				att.ThumbnailState = models.ThumbnailPending
+			}
+		}
+	}
+
+	if _, err := upload.Content.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var content io.Reader = upload.Content
+	// Photos often carry where they were taken; chat members get the
+	// picture, not the location.
+	switch mimeType {
+	case "image/jpeg":
+		header, err := media.ReadJPEGHeader(upload.Content)
+		if err != nil {
+			return nil, ErrInvalidImage.Wrap(err)
+		}
+		content = io.MultiReader(bytes.NewReader(header.Bytes), upload.Content)
+		att.Size += int64(len(header.Bytes)) - header.Consumed
+		if att.Width != nil {
+			w, h := media.Upright(*att.Width, *att.Height, header.Orientation)
+			att.Width, att.Height = &w, &h
+		}
+	case "image/png", "image/webp":
+		clean := media.CleanPNG
+		if mimeType == "image/webp" {
+			clean = media.CleanWebP
+		}
+		rw, err := clean(upload.Content)
+		if err != nil {
+			return nil, ErrInvalidImage.Wrap(err)
+		}
+		content = rw.Reader(upload.Content)
+		att.Size = rw.Size
+	}
+
+	hash := sha256.New()
+	if err := s.blobs.Put(ctx, att.StorageKey, io.TeeReader(content, hash), att.Size, mimeType); err != nil {
+		s.log.ErrorContext(ctx, "service: failed to store attachment", "chat_id", chatID, "error", err)
+		return nil, err
+	}
+	att.Checksum = hex.EncodeToString(hash.Sum(nil))
+
+	if err := s.attachments.Create(ctx, att); err != nil {
+		s.deleteContent(ctx, att.StorageKey)
+		return nil, err
+	}
+
+	if att.ThumbnailState == models.ThumbnailPending {
+		s.thumbnails.Enqueue(att.ID)
+	}
+
+	s.log.InfoContext(ctx, "service: attachment uploaded", "attachment_id", att.ID, "chat_id", chatID, "mime_type", mimeType, "size", att.Size)
+	res := toAttachmentResponse(att)
+	return &res, nil
+}
+
+func (s *attachmentService) Get(ctx context.Context, userID, id uint) (*dto.AttachmentResponse, error) {
+	ctx, span := tracing.Start(ctx, "AttachmentService.Get")
+	defer span.End()
+
+	att, err := s.load(ctx, userID, id)
+	if err != nil {
+		return nil, err
+	}
+	res := toAttachmentResponse(att)
+	return &res, nil
+}
+
+func (s *attachmentService) Open(ctx context.Context, userID, id uint) (*models.Attachment, io.ReadSeekCloser, error) {
+	ctx, span := tracing.Start(ctx, "AttachmentService.Open")
+	defer span.End()
+
+	att, err := s.load(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	content, err := s.blobs.Open(ctx, att.StorageKey)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			s.log.ErrorContext(ctx, "service: attachment content missing", "attachment_id", id)
+			return nil, nil, ErrAttachmentNotFound
+		}
+		return nil, nil, err
+	}
+	return att, content, nil
+}
+
+func (s *attachmentService) OpenThumbnail(ctx context.Context, userID, id uint, size int) (*models.AttachmentThumbnail, io.ReadSeekCloser, error) {
+	ctx, span := tracing.Start(ctx, "AttachmentService.OpenThumbnail")
+	defer span.End()
+
+	att, err := s.load(ctx, userID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range att.Thumbnails {
+		thumb := &att.Thumbnails[i]
+		if thumb.Size != size {
+			continue
+		}
+		content, err := s.blobs.Open(ctx, thumb.StorageKey)
+		if errors.Is(err, storage.ErrNotFound) {
+			s.log.ErrorContext(ctx, "service: thumbnail content missing", "attachment_id", id, "size", size)
+			return nil, nil, ErrThumbnailNotFound
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return thumb, content, nil
+	}
+	return nil, nil, ErrThumbnailNotFound
+}
+
+// load returns an attachment the user may see: one in a chat they belong to,
+// and if it is not sent yet, only to its uploader. Anything else looks like
+// it does not exist.
+func (s *attachmentService) load(ctx context.Context, userID, id uint) (*models.Attachment, error) {
+	att, err := s.attachments.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	if att.MessageID == nil && att.UploaderID != userID {
+		return nil, ErrAttachmentNotFound
+	}
+	if _, err := s.access.RequireMember(ctx, att.ChatID, userID); err != nil {
+		if errors.Is(err, ErrNotChatMember) || errors.Is(err, ErrChatNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return att, nil
+}
+
+func (s *attachmentService) allowed(mimeType string) bool {
+	for _, pattern := range s.cfg.AllowedTypes {
+		if family, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mimeType, family+"/") {
+				return true
+			}
+		} else if pattern == mimeType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *attachmentService) deleteContent(ctx context.Context, key string) {
+	if err := s.blobs.Delete(context.WithoutCancel(ctx), key); err != nil {
+		s.log.ErrorContext(ctx, "service: failed to delete attachment content", "key", key, "error", err)
+	}
+}
+
+// sniff detects the MIME type from the content, without parameters.
+func sniff(r io.Reader) (string, error) {
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(r, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return "", err
+	}
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(head[:n]))
+	if err != nil {
+		return "application/octet-stream", nil
+	}
+	return mediaType, nil
+}
+
+// cleanFileName keeps the base name of what the client sent, for display and
+// Content-Disposition only; storage keys never derive from it.
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	for utf8.RuneCountInString(name) > maxFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name
+}
+
+func toAttachmentResponse(a *models.Attachment) dto.AttachmentResponse {
+	return dto.AttachmentResponse{
+		ID:        a.ID,
+		ChatID:    a.ChatID,
+		MessageID: a.MessageID,
+		FileName:  a.FileName,
+		MimeType:  a.MimeType,
+		Size:      a.Size,
+		Checksum:  a.Checksum,
+		Width:     a.Width,
+		Height:    a.Height,
+		CreatedAt: a.CreatedAt,
+		URL:       fmt.Sprintf("/attachments/%d/content", a.ID),
+
+		ThumbnailsPending: a.ThumbnailState == models.ThumbnailPending,
+		Thumbnails:        toThumbnailResponses(a),
+	}
+}
+
+func toThumbnailResponses(a *models.Attachment) []dto.ThumbnailResponse {
+	if len(a.Thumbnails) == 0 {
+		return nil
+	}
+	res := make([]dto.ThumbnailResponse, 0, len(a.Thumbnails))
+	for _, t := range a.Thumbnails {
+		res = append(res, dto.ThumbnailResponse{
+			Size:     t.Size,
+			MimeType: t.MimeType,
+			Width:    t.Width,
+			Height:   t.Height,
+			Bytes:    t.Bytes,
+			URL:      fmt.Sprintf("/attachments/%d/thumbnails/%d", a.ID, t.Size),
+		})
+	}
+	return res
+}
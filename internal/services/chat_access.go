@@ -0,0 +1,71 @@
+package services
+
+import (
+	"context"
+	"errors"
+
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/tracing"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrChatNotFound   = apperr.New(apperr.NotFound, "chat_not_found", "chat not found")
+	ErrNotChatMember  = apperr.New(apperr.Forbidden, "not_chat_member", "user is not a member of this chat")
+	ErrSenderMismatch = apperr.New(apperr.Forbidden, "sender_mismatch", "sender_id does not match the authenticated user")
+)
+
+// ChatAccess is the single place that decides whether a user may read from
+// or write to a chat. Services call it before touching chat contents.
+type ChatAccess interface {
+	RequireMember(ctx context.Context, chatID, userID uint) (*models.Chat, error)
+}
+
+type chatAccess struct {
+	chats repository.ChatRepository
+}
+
+func NewChatAccess(chats repository.ChatRepository) ChatAccess {
+	return &chatAccess{chats: chats}
+}
+
+func (a *chatAccess) RequireMember(ctx context.Context, chatID, userID uint) (*models.Chat, error) {
+	ctx, span := tracing.Start(ctx, "ChatAccess.RequireMember")
+	defer span.End()
+
+	chat, err := a.chats.GetByID(ctx, chatID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrChatNotFound
+		}
+		return nil, err
+	}
+
+	if !isParticipant(chat, userID) {
+		return nil, ErrNotChatMember
+	}
+	return chat, nil
+}
+
+// isParticipant and participants expect chat.Members to be loaded.
+func isParticipant(chat *models.Chat, userID uint) bool {
+	if userID == 0 {
+		return false
+	}
+	for _, m := range chat.Members {
+		if m.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func participants(chat *models.Chat) []uint {
+	ids := make([]uint, 0, len(chat.Members))
+	for _, m := range chat.Members {
+		ids = append(ids, m.UserID)
+	}
+	return ids
+}
@@ -0,0 +1,425 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/metrics"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/tracing"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrNotGroupChat      = apperr.New(apperr.Invalid, "not_group_chat", "members can only be managed in group chats")
+	ErrInsufficientRole  = apperr.New(apperr.Forbidden, "insufficient_role", "not enough rights for this action")
+	ErrMemberNotFound    = apperr.New(apperr.NotFound, "member_not_found", "user is not a member of this chat")
+	ErrAlreadyMember     = apperr.New(apperr.Conflict, "already_member", "user is already a member of this chat")
+	ErrInvalidRole       = apperr.New(apperr.Invalid, "invalid_role", "role must be admin or member")
+	ErrCannotTargetSelf  = apperr.New(apperr.Invalid, "cannot_target_self", "this action cannot be applied to yourself")
+	ErrOwnerMustTransfer = apperr.New(apperr.Conflict, "owner_must_transfer", "owner must transfer ownership before leaving")
+	ErrNoUsersToAdd      = apperr.New(apperr.Invalid, "no_users_to_add", "no users to add")
+	ErrInvalidUserID     = apperr.New(apperr.Invalid, "invalid_user_id", "invalid user id")
+)
+
+// roleRank orders roles so that a member may only act on members ranked below.
+func roleRank(role string) int {
+	switch role {
+	case models.RoleOwner:
+		return 3
+	case models.RoleAdmin:
+		return 2
+	default:
+		return 1
+	}
+}
+
+func (s *chatService) AddMembers(ctx context.Context, actorID, chatID uint, userIDs []uint) error {
+	ctx, span := tracing.Start(ctx, "ChatService.AddMembers")
+	defer span.End()
+
+	chat, err := s.loadGroup(ctx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+
+	var newIDs []uint
+	seen := make(map[uint]bool)
+	for _, id := range userIDs {
+		if id == 0 {
+			return ErrInvalidUserID
+		}
+		if !seen[id] {
+			seen[id] = true
+			newIDs = append(newIDs, id)
+		}
+	}
+	if len(newIDs) == 0 {
+		return ErrNoUsersToAdd
+	}
+	check := func(members []models.ChatMember) error {
+		if _, err := requireRole(members, actorID, models.RoleAdmin); err != nil {
+			return err
+		}
+		for _, id := range newIDs {
+			if findMember(members, id) != nil {
+				return ErrAlreadyMember
+			}
+		}
+		if len(members)+len(newIDs) > maxGroupMembers {
+			return ErrGroupTooLarge
+		}
+		return nil
+	}
+	if err := check(chat.Members); err != nil {
+		return err
+	}
+
+	users, err := s.requireUsers(ctx, newIDs)
+	if err != nil {
+		return err
+	}
+	actorName, err := s.userName(ctx, actorID)
+	if err != nil {
+		return err
+	}
+
+	members := make([]models.ChatMember, 0, len(newIDs))
+	for _, id := range newIDs {
+		members = append(members, models.ChatMember{ChatID: chatID, UserID: id, Role: models.RoleMember})
+	}
+
+	text := fmt.Sprintf("%s added %s", actorName, joinNames(users))
+	return s.changeMembers(ctx, chatID, actorID, text, newIDs, func(chats repository.ChatRepository, current []models.ChatMember) error {
+		if err := check(current); err != nil {
+			return err
+		}
+		return chats.AddMembers(ctx, members)
+	})
+}
+
+func (s *chatService) RemoveMember(ctx context.Context, actorID, chatID, userID uint) error {
+	ctx, span := tracing.Start(ctx, "ChatService.RemoveMember")
+	defer span.End()
+
+	if actorID == userID {
+		return ErrCannotTargetSelf
+	}
+
+	chat, err := s.loadGroup(ctx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	check := func(members []models.ChatMember) error {
+		actor := findMember(members, actorID)
+		if actor == nil {
+			return ErrNotChatMember
+		}
+		target := findMember(members, userID)
+		if target == nil {
+			return ErrMemberNotFound
+		}
+		if roleRank(actor.Role) < roleRank(models.RoleAdmin) || roleRank(target.Role) >= roleRank(actor.Role) {
+			return ErrInsufficientRole
+		}
+		return nil
+	}
+	if err := check(chat.Members); err != nil {
+		return err
+	}
+
+	actorName, err := s.userName(ctx, actorID)
+	if err != nil {
+		return err
+	}
+	targetName, err := s.userName(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%s removed %s", actorName, targetName)
+	return s.changeMembers(ctx, chatID, actorID, text, nil, func(chats repository.ChatRepository, current []models.ChatMember) error {
+		if err := check(current); err != nil {
+			return err
+		}
+		return chats.RemoveMember(ctx, chatID, userID)
+	})
+}
+
+func (s *chatService) LeaveChat(ctx context.Context, userID, chatID uint) error {
+	ctx, span := tracing.Start(ctx, "ChatService.LeaveChat")
+	defer span.End()
+
+	chat, err := s.loadGroup(ctx, chatID, userID)
+	if err != nil {
+		return err
+	}
+	// The owner may only leave a chat they are alone in.
+	check := func(members []models.ChatMember) error {
+		member := findMember(members, userID)
+		if member == nil {
+			return ErrNotChatMember
+		}
+		if member.Role == models.RoleOwner && len(members) > 1 {
+			return ErrOwnerMustTransfer
+		}
+		return nil
+	}
+	if err := check(chat.Members); err != nil {
+		return err
+	}
+
+	if len(chat.Members) == 1 {
+		// Last one out: nobody is left to read a system message.
+		return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			chats := s.chats.WithTx(tx)
+			members, err := s.lockMembers(ctx, chats, chatID)
+			if err != nil {
+				return err
+			}
+			if err := check(members); err != nil {
+				return err
+			}
+			if err := chats.RemoveMember(ctx, chatID, userID); err != nil {
+				return err
+			}
+			if len(members) > 1 {
+				return nil
+			}
+			return chats.Delete(ctx, chatID)
+		})
+	}
+
+	name, err := s.userName(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%s left the chat", name)
+	return s.changeMembers(ctx, chatID, userID, text, nil, func(chats repository.ChatRepository, current []models.ChatMember) error {
+		if err := check(current); err != nil {
+			return err
+		}
+		return chats.RemoveMember(ctx, chatID, userID)
+	})
+}
+
+func (s *chatService) SetMemberRole(ctx context.Context, actorID, chatID, userID uint, role string) error {
+	ctx, span := tracing.Start(ctx, "ChatService.SetMemberRole")
+	defer span.End()
+
+	if role != models.RoleAdmin && role != models.RoleMember {
+		return ErrInvalidRole
+	}
+	if actorID == userID {
+		return ErrCannotTargetSelf
+	}
+
+	chat, err := s.loadGroup(ctx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	check := func(members []models.ChatMember) error {
+		if _, err := requireRole(members, actorID, models.RoleOwner); err != nil {
+			return err
+		}
+		target := findMember(members, userID)
+		if target == nil {
+			return ErrMemberNotFound
+		}
+		if target.Role == role {
+			return errRoleUnchanged
+		}
+		return nil
+	}
+	if err := check(chat.Members); err != nil {
+		return ignoreRoleUnchanged(err)
+	}
+
+	actorName, err := s.userName(ctx, actorID)
+	if err != nil {
+		return err
+	}
+	targetName, err := s.userName(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%s made %s an admin", actorName, targetName)
+	if role == models.RoleMember {
+		text = fmt.Sprintf("%s removed admin rights from %s", actorName, targetName)
+	}
+	err = s.changeMembers(ctx, chatID, actorID, text, nil, func(chats repository.ChatRepository, current []models.ChatMember) error {
+		if err := check(current); err != nil {
+			return err
+		}
+		return chats.SetMemberRole(ctx, chatID, userID, role)
+	})
+	return ignoreRoleUnchanged(err)
+}
+
+func (s *chatService) TransferOwnership(ctx context.Context, actorID, chatID, newOwnerID uint) error {
+	ctx, span := tracing.Start(ctx, "ChatService.TransferOwnership")
+	defer span.End()
+
+	if actorID == newOwnerID {
+		return ErrCannotTargetSelf
+	}
+
+	chat, err := s.loadGroup(ctx, chatID, actorID)
+	if err != nil {
+		return err
+	}
+	check := func(members []models.ChatMember) error {
+		if _, err := requireRole(members, actorID, models.RoleOwner); err != nil {
+			return err
+		}
+		if findMember(members, newOwnerID) == nil {
+			return ErrMemberNotFound
+		}
+		return nil
+	}
+	if err := check(chat.Members); err != nil {
+		return err
+	}
+
+	actorName, err := s.userName(ctx, actorID)
+	if err != nil {
+		return err
+	}
+	targetName, err := s.userName(ctx, newOwnerID)
+	if err != nil {
+		return err
+	}
+
+	text := fmt.Sprintf("%s transferred ownership to %s", actorName, targetName)
+	return s.changeMembers(ctx, chatID, actorID, text, nil, func(chats repository.ChatRepository, current []models.ChatMember) error {
+		// Of two concurrent transfers the second finds the actor demoted
+		// here and fails, so the chat never ends up with two owners.
+		if err := check(current); err != nil {
+			return err
+		}
+		if err := chats.SetMemberRole(ctx, chatID, newOwnerID, models.RoleOwner); err != nil {
+			return err
+		}
+		return chats.SetMemberRole(ctx, chatID, actorID, models.RoleAdmin)
+	})
+}
+
+// loadGroup returns a group chat the caller is a member of, with its members.
+// The members are a snapshot for early checks; changeMembers repeats them on
+// locked rows.
+func (s *chatService) loadGroup(ctx context.Context, chatID, userID uint) (*models.Chat, error) {
+	chat, err := s.access.RequireMember(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if chat.Type != models.ChatTypeGroup {
+		return nil, ErrNotGroupChat
+	}
+	return chat, nil
+}
+
+// changeMembers applies a membership change and records a system message in
+// one transaction, then notifies everyone who was a member before the change
+// (so removed users see it too) plus extraRecipients. apply gets the current
+// members with their rows locked and must check its preconditions again on
+// them: the chat may have changed since it was first loaded.
+func (s *chatService) changeMembers(ctx context.Context,
+	chatID uint,
+	actorID uint,
+	text string,
+	extraRecipients []uint,
+	apply func(chats repository.ChatRepository, members []models.ChatMember) error,
+) error {
+	msg := &models.Message{
+		ChatID:   chatID,
+		SenderID: actorID,
+		Type:     models.MessageTypeSystem,
+		Text:     text,
+	}
+
+	var recipients []uint
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		chats := s.chats.WithTx(tx)
+		members, err := s.lockMembers(ctx, chats, chatID)
+		if err != nil {
+			return err
+		}
+		if err := apply(chats, members); err != nil {
+			return err
+		}
+		for _, m := range members {
+			recipients = append(recipients, m.UserID)
+		}
+		return s.messages.WithTx(tx).Create(ctx, msg)
+	})
+	if err != nil {
+		return err
+	}
+	metrics.MessagesCreated.WithLabelValues(msg.Type).Inc()
+
+	recipients = append(recipients, extraRecipients...)
+	s.events.Publish(recipients, EventMessageCreated, toMessageResponse(msg))
+	return nil
+}
+
+func (s *chatService) lockMembers(ctx context.Context, chats repository.ChatRepository, chatID uint) ([]models.ChatMember, error) {
+	members, err := chats.LockMembers(ctx, chatID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrChatNotFound
+	}
+	return members, err
+}
+
+// errRoleUnchanged stops SetMemberRole when the member already has the role;
+// the call still succeeds.
+var errRoleUnchanged = errors.New("role unchanged")
+
+func ignoreRoleUnchanged(err error) error {
+	if errors.Is(err, errRoleUnchanged) {
+		return nil
+	}
+	return err
+}
+
+// requireRole returns the member userID if they hold at least role.
+func requireRole(members []models.ChatMember, userID uint, role string) (*models.ChatMember, error) {
+	member := findMember(members, userID)
+	if member == nil {
+		return nil, ErrNotChatMember
+	}
+	if roleRank(member.Role) < roleRank(role) {
+		return nil, ErrInsufficientRole
+	}
+	return member, nil
+}
+
+func (s *chatService) userName(ctx context.Context, id uint) (string, error) {
+	user, err := s.users.GetByID(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	return user.Name, nil
+}
+
+func findMember(members []models.ChatMember, userID uint) *models.ChatMember {
+	for i := range members {
+		if members[i].UserID == userID {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+func joinNames(users []models.User) string {
+	names := make([]string, 0, len(users))
+	for _, u := range users {
+		names = append(names, u.Name)
+	}
+	return strings.Join(names, ", ")
+}
@@ -1,105 +1,317 @@
 package services
 
 import (
+	"context"
 	"errors"
-	"sort"
-	"time"
+	"strings"
+	"unicode/utf8"
 
+	"github.com/DjMariarty/messenger/internal/apperr"
 	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/metrics"
 	"github.com/DjMariarty/messenger/internal/models"
 	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/tracing"
 	"gorm.io/gorm"
 )
 
 type ChatService interface {
-	CreateChat(userID uint, req dto.CreateChatRequest) (*models.Chat, error)
-	GetChats(userID uint) ([]dto.ChatResponse, error)
+	CreateChat(ctx context.Context, userID uint, req dto.CreateChatRequest) (*models.Chat, error)
+	GetChats(ctx context.Context, userID uint, query dto.ChatListQuery) (*dto.ChatPage, error)
+	MarkRead(ctx context.Context, userID, chatID uint, req dto.MarkReadRequest) (*dto.ReadReceipt, error)
+
+	AddMembers(ctx context.Context, actorID, chatID uint, userIDs []uint) error
+	RemoveMember(ctx context.Context, actorID, chatID, userID uint) error
+	LeaveChat(ctx context.Context, userID, chatID uint) error
+	SetMemberRole(ctx context.Context, actorID, chatID, userID uint, role string) error
+	TransferOwnership(ctx context.Context, actorID, chatID, newOwnerID uint) error
 }
 
+var (
+	ErrInvalidChatType    = apperr.New(apperr.Invalid, "invalid_chat_type", "chat type must be direct or group")
+	ErrGroupTitleRequired = apperr.New(apperr.Invalid, "group_title_required", "group chat title is required")
+	ErrTitleTooLong       = apperr.New(apperr.Invalid, "title_too_long", "chat title is too long")
+	ErrGroupTooSmall      = apperr.New(apperr.Invalid, "group_too_small", "group chat needs at least one other member")
+	ErrGroupTooLarge      = apperr.New(apperr.Invalid, "group_too_large", "group chat has too many members")
+	ErrChatWithSelf       = apperr.New(apperr.Invalid, "chat_with_self", "cannot create chat with yourself")
+	ErrUnknownMembers     = apperr.New(apperr.Invalid, "unknown_members", "some members do not exist")
+)
+
+const (
+	maxGroupMembers = 200
+	maxTitleLength  = 128
+)
+
 type chatService struct {
-	db    *gorm.DB
-	chats repository.ChatRepository
+	db       *gorm.DB
+	chats    repository.ChatRepository
+	users    repository.UserRepository
+	messages repository.MessageRepository
+	access   ChatAccess
+	events   EventPublisher
 }
 
-func NewChatService(db *gorm.DB, chats repository.ChatRepository) ChatService {
-	return &chatService{db: db, chats: chats}
+func NewChatService(
+	db *gorm.DB,
+	chats repository.ChatRepository,
+	users repository.UserRepository,
+	messages repository.MessageRepository,
+	access ChatAccess,
+	events EventPublisher,
+) ChatService {
+	return &chatService{
+		db:       db,
+		chats:    chats,
+		users:    users,
+		messages: messages,
+		access:   access,
+		events:   events,
+	}
 }
 
-func (s *chatService) CreateChat(userID uint, req dto.CreateChatRequest) (*models.Chat, error) {
-	if userID == 0 || req.PartnerID == 0 {
-		return nil, errors.New("invalid user id")
+func (s *chatService) CreateChat(ctx context.Context, userID uint, req dto.CreateChatRequest) (*models.Chat, error) {
+	ctx, span := tracing.Start(ctx, "ChatService.CreateChat")
+	defer span.End()
+
+	chatType := req.Type
+	if chatType == "" {
+		chatType = models.ChatTypeDirect
+		if len(req.MemberIDs) > 0 {
+			chatType = models.ChatTypeGroup
+		}
 	}
-	if userID == req.PartnerID {
-		return nil, errors.New("cannot create chat with yourself")
+
+	switch chatType {
+	case models.ChatTypeDirect:
+		partnerID := req.PartnerID
+		if partnerID == 0 && len(req.MemberIDs) == 1 {
+			partnerID = req.MemberIDs[0]
+		}
+		return s.createDirectChat(ctx, userID, partnerID)
+	case models.ChatTypeGroup:
+		return s.createGroupChat(ctx, userID, req)
+	default:
+		return nil, ErrInvalidChatType
 	}
+}
 
+func (s *chatService) createDirectChat(ctx context.Context, userID, partnerID uint) (*models.Chat, error) {
+	if userID == 0 || partnerID == 0 {
+		return nil, ErrInvalidUserID
+	}
+	if userID == partnerID {
+		return nil, ErrChatWithSelf
+	}
 
-	u1, u2 := userID, req.PartnerID
+	u1, u2 := userID, partnerID
 	if u1 > u2 {
 		u1, u2 = u2, u1
 	}
 
-
-	existing, err := s.chats.FindByUsers(u1, u2)
+	existing, err := s.chats.FindByUsers(ctx, u1, u2)
 	if err == nil {
 		return existing, nil
 	}
-	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
 
+	if _, err := s.requireUsers(ctx, []uint{partnerID}); err != nil {
+		return nil, err
+	}
 
-	chat := models.Chat{User1ID: u1, User2ID: u2}
-	if err := s.chats.Create(&chat); err != nil {
+	chat := models.Chat{
+		Type:    models.ChatTypeDirect,
+		User1ID: &u1,
+		User2ID: &u2,
+		Members: []models.ChatMember{
+			{UserID: u1, Role: models.RoleMember},
+			{UserID: u2, Role: models.RoleMember},
+		},
+	}
+	if err := s.chats.Create(ctx, &chat); err != nil {
+		// The pair was created by a concurrent request; that chat is the
+		// answer to this one too.
+		if errors.Is(err, repository.ErrDirectChatExists) {
+			return s.chats.FindByUsers(ctx, u1, u2)
+		}
 		return nil, err
 	}
+	metrics.ChatsCreated.WithLabelValues(chat.Type).Inc()
 
 	return &chat, nil
 }
 
-func (s *chatService) GetChats(userID uint) ([]dto.ChatResponse, error) {
-	chats, err := s.chats.GetUserChats(userID)
+func (s *chatService) createGroupChat(ctx context.Context, userID uint, req dto.CreateChatRequest) (*models.Chat, error) {
+	if userID == 0 {
+		return nil, ErrInvalidUserID
+	}
+
+	title := strings.TrimSpace(req.Title)
+	if title == "" {
+		return nil, ErrGroupTitleRequired
+	}
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		return nil, ErrTitleTooLong
+	}
+
+	memberIDs := []uint{userID}
+	seen := map[uint]bool{userID: true}
+	for _, id := range req.MemberIDs {
+		if id == 0 {
+			return nil, ErrInvalidUserID
+		}
+		if !seen[id] {
+			seen[id] = true
+			memberIDs = append(memberIDs, id)
+		}
+	}
+	if len(memberIDs) < 2 {
+		return nil, ErrGroupTooSmall
+	}
+	if len(memberIDs) > maxGroupMembers {
+		return nil, ErrGroupTooLarge
+	}
+
+	if _, err := s.requireUsers(ctx, memberIDs[1:]); err != nil {
+		return nil, err
+	}
+
+	members := make([]models.ChatMember, 0, len(memberIDs))
+	for _, id := range memberIDs {
+		role := models.RoleMember
+		if id == userID {
+			role = models.RoleOwner
+		}
+		members = append(members, models.ChatMember{UserID: id, Role: role})
+	}
+
+	chat := models.Chat{
+		Type:    models.ChatTypeGroup,
+		Title:   title,
+		Members: members,
+	}
+	if err := s.chats.Create(ctx, &chat); err != nil {
+		return nil, err
+	}
+	metrics.ChatsCreated.WithLabelValues(chat.Type).Inc()
+
+	return &chat, nil
+}
+
+// requireUsers loads the given users and fails if any of them is missing.
+func (s *chatService) requireUsers(ctx context.Context, ids []uint) ([]models.User, error) {
+	users, err := s.users.GetByIDs(ctx, ids)
 	if err != nil {
 		return nil, err
 	}
+	if len(users) != len(ids) {
+		found := make(map[uint]bool, len(users))
+		for _, u := range users {
+			found[u.ID] = true
+		}
+		missing := make([]uint, 0, len(ids)-len(users))
+		for _, id := range ids {
+			if !found[id] {
+				missing = append(missing, id)
+			}
+		}
+		return nil, ErrUnknownMembers.WithDetails(map[string]any{"user_ids": missing})
+	}
+	return users, nil
+}
 
-	res := make([]dto.ChatResponse, 0, len(chats))
+func (s *chatService) GetChats(ctx context.Context, userID uint, query dto.ChatListQuery) (*dto.ChatPage, error) {
+	ctx, span := tracing.Start(ctx, "ChatService.GetChats")
+	defer span.End()
 
-	for _, ch := range chats {
-		lastMsg, err := s.chats.GetLastMessage(ch.ID)
+	page := repository.ChatListQuery{Limit: clampPageSize(query.Limit)}
+	if query.Before != "" {
+		cursor, err := decodeCursor(query.Before)
 		if err != nil {
 			return nil, err
 		}
+		page.Before = cursor
+	}
+
+	// Ask for one extra row to learn whether another page exists.
+	limit := page.Limit
+	page.Limit++
+	rows, err := s.chats.ListSummaries(ctx, userID, page)
+	if err != nil {
+		return nil, err
+	}
 
-		var lastText string
-		var lastTime *time.Time
-		if lastMsg != nil {
-			lastText = lastMsg.Text
-			t := lastMsg.CreatedAt
-			lastTime = &t
+	res := &dto.ChatPage{Chats: make([]dto.ChatResponse, 0, min(len(rows), limit))}
+	if len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[limit-1]
+		res.NextCursor = encodeCursor(last.ActivityAt, last.ChatID)
+	}
+
+	for _, row := range rows {
+		item := dto.ChatResponse{
+			ChatID:      row.ChatID,
+			Type:        row.Type,
+			Title:       row.Title,
+			UnreadCount: row.UnreadCount,
 		}
+		if row.LastMessageID != nil {
+			item.LastMessage = *row.LastMessageText
+			item.LastMessageTime = row.LastMessageAt
+			item.LastEdited = row.LastMessageEditedAt != nil
+			item.LastDeleted = row.LastMessageRemovedAt != nil
+		}
+		res.Chats = append(res.Chats, item)
+	}
 
-		res = append(res, dto.ChatResponse{
-			ChatID:          ch.ID,
-			LastMessage:     lastText,
-			LastMessageTime: lastTime,
-		})
+	return res, nil
+}
+
+// MarkRead marks everything up to req.MessageID (or the newest message when it
+// is zero) as read by userID and tells the other members about it.
+func (s *chatService) MarkRead(ctx context.Context, userID, chatID uint, req dto.MarkReadRequest) (*dto.ReadReceipt, error) {
+	ctx, span := tracing.Start(ctx, "ChatService.MarkRead")
+	defer span.End()
+
+	chat, err := s.access.RequireMember(ctx, chatID, userID)
+	if err != nil {
+		return nil, err
 	}
 
-	sort.Slice(res, func(i, j int) bool {
-		ti := res[i].LastMessageTime
-		tj := res[j].LastMessageTime
-		if ti == nil && tj == nil {
-			return false
+	messageID := req.MessageID
+	if messageID == 0 {
+		last, err := s.chats.GetLastMessage(ctx, chatID, userID)
+		if err != nil {
+			return nil, err
+		}
+		if last == nil {
+			return &dto.ReadReceipt{ChatID: chatID, UserID: userID}, nil
 		}
-		if ti == nil {
-			return false
+		messageID = last.ID
+	} else {
+		msg, err := s.messages.GetByID(ctx, messageID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrMessageNotFound
+			}
+			return nil, err
 		}
-		if tj == nil {
-			return true
+		// Threads keep their own read pointers, see MarkThreadRead.
+		if msg.ChatID != chatID || msg.ThreadRootID != nil {
+			return nil, ErrMessageNotFound
 		}
-		return ti.After(*tj)
-	})
+	}
 
-	return res, nil
+	if err := s.chats.MarkRead(ctx, chatID, userID, messageID); err != nil {
+		return nil, err
+	}
+
+	receipt := &dto.ReadReceipt{ChatID: chatID, UserID: userID, LastReadMessageID: messageID}
+	if member := findMember(chat.Members, userID); member != nil && member.LastReadMessageID != nil && *member.LastReadMessageID > messageID {
+		receipt.LastReadMessageID = *member.LastReadMessageID
+	}
+
+	s.events.Publish(participants(chat), EventChatRead, receipt)
+	return receipt, nil
 }
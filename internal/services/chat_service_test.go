@@ -0,0 +1,195 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newCountingDB returns a GORM handle over sqlmock and a counter of the
+// statements GORM sends through it.
+func newCountingDB(tb testing.TB) (*gorm.DB, sqlmock.Sqlmock, *atomic.Int64) {
+	tb.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { sqlDB.Close() })
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	var queries atomic.Int64
+	count := func(*gorm.DB) { queries.Add(1) }
+	cb := db.Callback()
+	for _, err := range []error{
+		cb.Query().After("gorm:query").Register("test:count_query", count),
+		cb.Raw().After("gorm:raw").Register("test:count_raw", count),
+		cb.Row().After("gorm:row").Register("test:count_row", count),
+		cb.Create().After("gorm:create").Register("test:count_create", count),
+		cb.Update().After("gorm:update").Register("test:count_update", count),
+		cb.Delete().After("gorm:delete").Register("test:count_delete", count),
+	} {
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+	return db, mock, &queries
+}
+
+var chatListQuery = regexp.QuoteMeta("SELECT c.id AS chat_id")
+
+// expectChatList makes the mock answer the chat list query with n chats,
+// each with a last message and some unread ones, after delay.
+func expectChatList(mock sqlmock.Sqlmock, n int, delay time.Duration) {
+	rows := sqlmock.NewRows([]string{
+		"chat_id", "type", "title", "activity_at",
+		"last_message_id", "last_message_text", "last_message_at",
+		"last_message_edited_at", "last_message_removed_at", "unread_count",
+	})
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range n {
+		sent := at.Add(-time.Duration(i) * time.Minute)
+		rows.AddRow(i+1, "group", fmt.Sprintf("chat %d", i+1), sent, 1000+i, "hello", sent, nil, nil, i%3)
+	}
+	mock.ExpectQuery(chatListQuery).WillDelayFor(delay).WillReturnRows(rows)
+}
+
+func newTestChatService(db *gorm.DB) ChatService {
+	chats := repository.NewChatRepository(db)
+	return NewChatService(db, chats, nil, nil, NewChatAccess(chats), &fakeEvents{})
+}
+
+// Two requests racing to open the same direct chat both get the one that
+// was created.
+func TestCreateDirectChatConcurrently(t *testing.T) {
+	u1, u2 := uint(1), uint(2)
+	winner := &models.Chat{Type: models.ChatTypeDirect, User1ID: &u1, User2ID: &u2}
+	winner.ID = 7
+	chats := &fakeChatRepository{chats: map[uint]*models.Chat{}}
+	chats.beforeCreate = func() { chats.chats[winner.ID] = winner }
+
+	svc := NewChatService(nil, chats, &fakeUserRepository{}, nil, NewChatAccess(chats), &fakeEvents{})
+	chat, err := svc.CreateChat(context.Background(), 2, dto.CreateChatRequest{Type: models.ChatTypeDirect, PartnerID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chat.ID != winner.ID {
+		t.Fatalf("got chat %d, want %d", chat.ID, winner.ID)
+	}
+}
+
+// The chat list is one query however many chats the page holds.
+func TestGetChatsQueryCount(t *testing.T) {
+	for _, n := range []int{1, 10, 50} {
+		t.Run(fmt.Sprintf("%d chats", n), func(t *testing.T) {
+			db, mock, queries := newCountingDB(t)
+			expectChatList(mock, n, 0)
+
+			page, err := newTestChatService(db).GetChats(context.Background(), 1, dto.ChatListQuery{Limit: 50})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Chats) != n {
+				t.Fatalf("got %d chats, want %d", len(page.Chats), n)
+			}
+			if got := queries.Load(); got != 1 {
+				t.Fatalf("GetChats ran %d queries for %d chats, want 1", got, n)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// benchmarkRoundTrip is the latency the benchmarks give every query, so that
+// they measure round trips rather than how fast sqlmock answers.
+const benchmarkRoundTrip = 200 * time.Microsecond
+
+// perChatGetChats replays how GetChats used to build the list: the user's
+// chats, their unread counts, then one query for each chat's last message.
+func perChatGetChats(db *gorm.DB, userID uint) error {
+	var chats []models.Chat
+	err := db.Joins("JOIN chat_members cm ON cm.chat_id = chats.id AND cm.user_id = ?", userID).
+		Find(&chats).Error
+	if err != nil {
+		return err
+	}
+	var unread []struct {
+		ChatID uint
+		Unread int64
+	}
+	if err := db.Raw("SELECT cm.chat_id, COUNT(m.id) AS unread FROM chat_members cm JOIN messages m ON m.chat_id = cm.chat_id WHERE cm.user_id = ? GROUP BY cm.chat_id", userID).
+		Scan(&unread).Error; err != nil {
+		return err
+	}
+	for _, chat := range chats {
+		var last models.Message
+		if err := db.Where("chat_id = ?", chat.ID).Order("created_at DESC, id DESC").Limit(1).Find(&last).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expectPerChatList(mock sqlmock.Sqlmock, n int) {
+	chats := sqlmock.NewRows([]string{"id", "type", "title"})
+	unread := sqlmock.NewRows([]string{"chat_id", "unread"})
+	for i := range n {
+		chats.AddRow(i+1, "group", fmt.Sprintf("chat %d", i+1))
+		unread.AddRow(i+1, i%3)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "chats" JOIN chat_members`)).WillDelayFor(benchmarkRoundTrip).WillReturnRows(chats)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT cm.chat_id")).WillDelayFor(benchmarkRoundTrip).WillReturnRows(unread)
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := range n {
+		mock.ExpectQuery(regexp.QuoteMeta(`FROM "messages" WHERE chat_id`)).
+			WillDelayFor(benchmarkRoundTrip).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "chat_id", "text", "created_at"}).AddRow(1000+i, i+1, "hello", at))
+	}
+}
+
+// BenchmarkGetChats compares the single-query chat list with the per-chat
+// queries it replaced; compare the time and queries/op of the two.
+func BenchmarkGetChats(b *testing.B) {
+	for _, n := range []int{10, 50} {
+		b.Run(fmt.Sprintf("single query/%d chats", n), func(b *testing.B) {
+			db, mock, queries := newCountingDB(b)
+			svc := newTestChatService(db)
+			for b.Loop() {
+				b.StopTimer()
+				expectChatList(mock, n, benchmarkRoundTrip)
+				b.StartTimer()
+				if _, err := svc.GetChats(context.Background(), 1, dto.ChatListQuery{Limit: 50}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+		})
+		b.Run(fmt.Sprintf("per chat/%d chats", n), func(b *testing.B) {
+			db, mock, queries := newCountingDB(b)
+			for b.Loop() {
+				b.StopTimer()
+				expectPerChatList(mock, n)
+				b.StartTimer()
+				if err := perChatGetChats(db, 1); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(queries.Load())/float64(b.N), "queries/op")
+		})
+	}
+}
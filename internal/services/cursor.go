@@ -0,0 +1,87 @@
+package services
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/repository"
+)
+
+var ErrInvalidCursor = apperr.New(apperr.Invalid, "invalid_cursor", "invalid cursor")
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 100
+)
+
+// Cursors are opaque to clients: base64url("<unix micros>:<id>"). Postgres
+// keeps microsecond precision, so the round trip is exact.
+func encodeCursor(t time.Time, id uint) string {
+	raw := strconv.FormatInt(t.UnixMicro(), 10) + ":" + strconv.FormatUint(uint64(id), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (*repository.Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return parseCursor(string(raw))
+}
+
+func parseCursor(raw string) (*repository.Cursor, error) {
+	micros, id, ok := strings.Cut(raw, ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	us, err := strconv.ParseInt(micros, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	n, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || n == 0 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &repository.Cursor{Time: time.UnixMicro(us), ID: uint(n)}, nil
+}
+
+func clampPageSize(limit int) int {
+	if limit <= 0 {
+		return defaultPageSize
+	}
+	return min(limit, maxPageSize)
+}
+
+// Search cursors add the rank in front: base64url("<rank>:<unix micros>:<id>").
+// The rank is printed with float32 precision so that it round-trips exactly.
+func encodeSearchCursor(rank float32, t time.Time, id uint) string {
+	raw := strconv.FormatFloat(float64(rank), 'g', -1, 32) + ":" +
+		strconv.FormatInt(t.UnixMicro(), 10) + ":" + strconv.FormatUint(uint64(id), 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeSearchCursor(s string) (*repository.SearchCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	rank, rest, ok := strings.Cut(string(raw), ":")
+	if !ok {
+		return nil, ErrInvalidCursor
+	}
+	r, err := strconv.ParseFloat(rank, 32)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c, err := parseCursor(rest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &repository.SearchCursor{Rank: float32(r), Time: c.Time, ID: c.ID}, nil
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"gorm.io/gorm"
+)
+
+var discardLog = slog.New(slog.DiscardHandler)
+
+// The fakes embed the repository interfaces, so a test that reaches a method
+// it did not expect panics instead of silently passing.
+
+type fakeChatRepository struct {
+	repository.ChatRepository
+	chats map[uint]*models.Chat
+	// beforeCreate runs inside Create, standing in for a concurrent request.
+	beforeCreate func()
+}
+
+func (r *fakeChatRepository) GetByID(_ context.Context, id uint) (*models.Chat, error) {
+	chat, ok := r.chats[id]
+	if !ok {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return chat, nil
+}
+
+func (r *fakeChatRepository) FindByUsers(_ context.Context, user1ID, user2ID uint) (*models.Chat, error) {
+	for _, chat := range r.chats {
+		if chat.Type == models.ChatTypeDirect && *chat.User1ID == user1ID && *chat.User2ID == user2ID {
+			return chat, nil
+		}
+	}
+	return nil, gorm.ErrRecordNotFound
+}
+
+// Create enforces the unique direct pair like the database does.
+func (r *fakeChatRepository) Create(ctx context.Context, chat *models.Chat) error {
+	if r.beforeCreate != nil {
+		r.beforeCreate()
+	}
+	if chat.Type == models.ChatTypeDirect {
+		if _, err := r.FindByUsers(ctx, *chat.User1ID, *chat.User2ID); err == nil {
+			return repository.ErrDirectChatExists
+		}
+	}
+	chat.ID = uint(len(r.chats) + 1)
+	r.chats[chat.ID] = chat
+	return nil
+}
+
+type fakeUserRepository struct {
+	repository.UserRepository
+}
+
+// GetByIDs finds every user.
+func (r *fakeUserRepository) GetByIDs(_ context.Context, ids []uint) ([]models.User, error) {
+	users := make([]models.User, len(ids))
+	for i, id := range ids {
+		users[i].ID = id
+	}
+	return users, nil
+}
+
+type fakeMessageRepository struct {
+	repository.MessageRepository
+	mu       sync.Mutex
+	messages []models.Message
+}
+
+func (r *fakeMessageRepository) Create(_ context.Context, message *models.Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	message.ID = uint(len(r.messages) + 1)
+	r.messages = append(r.messages, *message)
+	return nil
+}
+
+func (r *fakeMessageRepository) ListByChat(_ context.Context, chatID uint, query repository.MessageQuery) ([]models.Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []models.Message
+	for i := len(r.messages) - 1; i >= 0 && len(out) < query.Limit; i-- {
+		if r.messages[i].ChatID == chatID {
+			out = append(out, r.messages[i])
+		}
+	}
+	return out, nil
+}
+
+type fakeEvents struct {
+	mu        sync.Mutex
+	published []string
+}
+
+func (e *fakeEvents) Publish(_ []uint, eventType string, _ any) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.published = append(e.published, eventType)
+}
+
+// testChat builds a chat whose members are memberIDs.
+func testChat(id uint, chatType string, memberIDs ...uint) *models.Chat {
+	chat := &models.Chat{Type: chatType}
+	chat.ID = id
+	for _, uid := range memberIDs {
+		chat.Members = append(chat.Members, models.ChatMember{ChatID: id, UserID: uid})
+	}
+	return chat
+}
@@ -1,77 +1,699 @@
 package services
 
 import (
+	"context"
 	"errors"
+	"html"
 	"log/slog"
+	"strings"
 
+	"github.com/DjMariarty/messenger/internal/apperr"
 	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/metrics"
 	"github.com/DjMariarty/messenger/internal/models"
 	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/storage"
+	"github.com/DjMariarty/messenger/internal/tracing"
+	"gorm.io/gorm"
 )
 
 var (
-	ErrInvalidChatID   = errors.New("chatID cannot be 0")
-	ErrInvalidSenderID = errors.New("senderID cannot be 0")
-	ErrEmptyMessage    = errors.New("text  cannot be empty")
+	ErrInvalidChatID   = apperr.New(apperr.Invalid, "invalid_chat_id", "chat_id cannot be 0")
+	ErrInvalidSenderID = apperr.New(apperr.Invalid, "invalid_sender_id", "sender_id cannot be 0")
+	ErrEmptyMessage    = apperr.New(apperr.Invalid, "empty_message", "text cannot be empty")
+	ErrConflictingPage = apperr.New(apperr.Invalid, "conflicting_page", "before and after cannot be used together")
+
+	ErrMessageNotFound    = apperr.New(apperr.NotFound, "message_not_found", "message not found")
+	ErrNotMessageSender   = apperr.New(apperr.Forbidden, "not_message_sender", "only the sender can change this message")
+	ErrMessageNotEditable = apperr.New(apperr.Conflict, "message_not_editable", "message cannot be changed")
+	ErrInvalidDeleteScope = apperr.New(apperr.Invalid, "invalid_delete_scope", "scope must be me or everyone")
+	ErrInvalidReplyTarget = apperr.New(apperr.Invalid, "invalid_reply_target", "reply must quote a message in the same chat")
+	ErrInvalidThreadRoot  = apperr.New(apperr.Invalid, "invalid_thread_root", "threads start at a top-level message of the same chat")
+	ErrThreadsGroupOnly   = apperr.New(apperr.Invalid, "threads_group_only", "threads are only available in group chats")
+)
+
+// previewLength is how many characters of a quoted message's text a reply
+// shows.
+const previewLength = 100
+
+const (
+	DeleteForMe       = "me"
+	DeleteForEveryone = "everyone"
 )
 
 type MessageService interface {
-	CreateMessage(req dto.CreateMessageRequest) (*models.Message, error)
-	GetMessagesByChatID(chatID uint) ([]models.Message, error)
+	CreateMessage(ctx context.Context, userID uint, req dto.CreateMessageRequest) (*models.Message, error)
+	ListMessages(ctx context.Context, userID, chatID uint, query dto.MessageHistoryQuery) (*dto.MessagePage, error)
+
+	EditMessage(ctx context.Context, userID, messageID uint, req dto.EditMessageRequest) (*dto.MessageResponse, error)
+	DeleteMessage(ctx context.Context, userID, messageID uint, scope string) error
+	ListEdits(ctx context.Context, userID, messageID uint) ([]dto.MessageEditResponse, error)
+
+	ListThread(ctx context.Context, userID, rootID uint, query dto.MessageHistoryQuery) (*dto.ThreadPage, error)
+	MarkThreadRead(ctx context.Context, userID, rootID uint, req dto.MarkReadRequest) (*dto.ThreadReadReceipt, error)
+
+	SearchMessages(ctx context.Context, userID uint, query dto.MessageSearchQuery) (*dto.MessageSearchPage, error)
 }
 
+// EventPublisher pushes events to the live connections of the given users.
+type EventPublisher interface {
+	Publish(userIDs []uint, eventType string, payload any)
+}
+
+const (
+	EventMessageCreated = "message.created"
+	EventMessageUpdated = "message.updated"
+	EventMessageDeleted = "message.deleted"
+	EventChatRead       = "chat.read"
+	EventThreadRead     = "thread.read"
+)
+
 type messageService struct {
 	messages repository.MessageRepository
+	access   ChatAccess
+	events   EventPublisher
+	blobs    storage.Blob
 	log      *slog.Logger
 }
 
-func NewMessageService(messages repository.MessageRepository, log *slog.Logger) MessageService {
-	return &messageService{messages: messages, log: log}
+func NewMessageService(
+	messages repository.MessageRepository,
+	access ChatAccess,
+	events EventPublisher,
+	blobs storage.Blob,
+	log *slog.Logger,
+) MessageService {
+	return &messageService{messages: messages, access: access, events: events, blobs: blobs, log: log}
 }
 
-func (s *messageService) CreateMessage(req dto.CreateMessageRequest) (*models.Message, error) {
+func (s *messageService) CreateMessage(ctx context.Context, userID uint, req dto.CreateMessageRequest) (*models.Message, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.CreateMessage")
+	defer span.End()
+
 	if req.ChatID == 0 {
-		s.log.Warn("service: invalid chatID")
+		s.log.WarnContext(ctx, "service: invalid chatID")
 		return nil, ErrInvalidChatID
 	}
 
-	if req.SenderID == 0 {
-		s.log.Warn("service: invalid senderID")
+	if userID == 0 {
+		s.log.WarnContext(ctx, "service: invalid senderID")
 		return nil, ErrInvalidSenderID
 	}
 
-	if req.Text == "" {
-		s.log.Warn("service: empty message text")
+	// sender_id is optional in the body; when present it must be the caller.
+	if req.SenderID != 0 && req.SenderID != userID {
+		s.log.WarnContext(ctx, "service: sender mismatch", "user_id", userID, "sender_id", req.SenderID)
+		return nil, ErrSenderMismatch
+	}
+
+	attachmentIDs := uniqueIDs(req.AttachmentIDs)
+	if len(attachmentIDs) > maxAttachmentsPerMessage {
+		return nil, ErrTooManyAttachments.WithDetails(map[string]any{"max": maxAttachmentsPerMessage})
+	}
+	if req.Text == "" && len(attachmentIDs) == 0 {
+		s.log.WarnContext(ctx, "service: empty message text")
 		return nil, ErrEmptyMessage
 	}
+
+	chat, err := s.access.RequireMember(ctx, req.ChatID, userID)
+	if err != nil {
+		s.log.WarnContext(ctx, "service: create message denied", "chat_id", req.ChatID, "user_id", userID, "error", err)
+		return nil, err
+	}
 	// ---------------------------------------------------
 	msg := &models.Message{
 		ChatID:   req.ChatID,
-		SenderID: req.SenderID,
+		SenderID: userID,
+		Type:     models.MessageTypeText,
 		Text:     req.Text,
 	}
+	if req.ReplyToMessageID != nil {
+		quoted, err := s.messages.GetByID(ctx, *req.ReplyToMessageID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err != nil || quoted.ChatID != req.ChatID {
+			s.log.WarnContext(ctx, "service: invalid reply target", "chat_id", req.ChatID, "reply_to_message_id", *req.ReplyToMessageID)
+			return nil, ErrInvalidReplyTarget
+		}
+		msg.ReplyToMessageID = &quoted.ID
+		msg.ReplyTo = quoted
+	}
+	if req.ThreadRootID != nil {
+		if chat.Type != models.ChatTypeGroup {
+			return nil, ErrThreadsGroupOnly
+		}
+		root, err := s.messages.GetByID(ctx, *req.ThreadRootID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if err != nil || !isThreadRoot(root, req.ChatID) {
+			s.log.WarnContext(ctx, "service: invalid thread root", "chat_id", req.ChatID, "thread_root_id", *req.ThreadRootID)
+			return nil, ErrInvalidThreadRoot
+		}
+		msg.ThreadRootID = &root.ID
+	}
 
-	err := s.messages.Create(msg)
+	if len(attachmentIDs) > 0 {
+		err = s.messages.CreateWithAttachments(ctx, msg, attachmentIDs)
+	} else {
+		err = s.messages.Create(ctx, msg)
+	}
+	if errors.Is(err, repository.ErrAttachmentsUnavailable) {
+		s.log.WarnContext(ctx, "service: attachments unavailable", "chat_id", req.ChatID, "sender_id", userID)
+		return nil, ErrAttachmentsUnavailable
+	}
 	if err != nil {
-		s.log.Error("service: failed to create message", "chat_id", req.ChatID, "sender_id", req.SenderID, "error", err)
+		s.log.ErrorContext(ctx, "service: failed to create message", "chat_id", req.ChatID, "sender_id", userID, "error", err)
 		return nil, err
 	}
-	s.log.Info("service: message created", "message_id", msg.ID, "chat_id", msg.ChatID, "sender_id", msg.SenderID)
+	s.log.InfoContext(ctx, "service: message created", "message_id", msg.ID, "chat_id", msg.ChatID, "sender_id", msg.SenderID)
+	metrics.MessagesCreated.WithLabelValues(msg.Type).Inc()
+
+	s.events.Publish(participants(chat), EventMessageCreated, toMessageResponse(msg))
+	if msg.ThreadRootID != nil {
+		s.publishThreadRoot(ctx, chat, *msg.ThreadRootID)
+	}
 	return msg, nil
 }
 
-func (s *messageService) GetMessagesByChatID(userID uint) ([]models.Message, error) {
-	if userID == 0 {
-		s.log.Warn("service: invalid userID (0)")
-		return nil, errors.New("chatID cannot be 0")
+func (s *messageService) ListMessages(ctx context.Context, userID, chatID uint, query dto.MessageHistoryQuery) (*dto.MessagePage, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.ListMessages")
+	defer span.End()
+
+	if chatID == 0 {
+		s.log.WarnContext(ctx, "service: invalid chatID (0)")
+		return nil, ErrInvalidChatID
+	}
+
+	page, err := pageQuery(query)
+	page.ViewerID = userID
+	if err != nil {
+		s.log.WarnContext(ctx, "service: invalid history query", "chat_id", chatID, "error", err)
+		return nil, err
+	}
+
+	chat, err := s.access.RequireMember(ctx, chatID, userID)
+	if err != nil {
+		s.log.WarnContext(ctx, "service: read messages denied", "chat_id", chatID, "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	// Ask for one extra row to learn whether another page exists.
+	limit := page.Limit
+	page.Limit++
+	messages, err := s.messages.ListByChat(ctx, chatID, page)
+	if err != nil {
+		s.log.ErrorContext(ctx, "service: failed to fetch messages", "chat_id", chatID, "error", err)
+		return nil, err
+	}
+
+	res := &dto.MessagePage{Messages: make([]dto.MessageResponse, 0, min(len(messages), limit))}
+	if len(messages) > limit {
+		messages = messages[:limit]
+		last := messages[limit-1]
+		res.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	partnerRead := directPartnerReadPointer(chat, userID)
+	for i := range messages {
+		item := toMessageResponse(&messages[i])
+		if partnerRead != nil && item.SenderID == userID {
+			read := item.ID <= *partnerRead
+			item.Read = &read
+		}
+		res.Messages = append(res.Messages, item)
+	}
+	if err := s.countThreadUnread(ctx, userID, res.Messages); err != nil {
+		return nil, err
+	}
+
+	s.log.InfoContext(ctx, "service: message fetched", "chat_id", chatID, "count", len(res.Messages))
+	return res, nil
+}
+
+func (s *messageService) EditMessage(ctx context.Context, userID, messageID uint, req dto.EditMessageRequest) (*dto.MessageResponse, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.EditMessage")
+	defer span.End()
+
+	if req.Text == "" {
+		return nil, ErrEmptyMessage
 	}
 
-	messages, err := s.messages.GetMessagesByChatID(userID)
+	msg, chat, err := s.loadMessage(ctx, userID, messageID)
 	if err != nil {
-		s.log.Error("service: failed to fetch messages", "user_id", userID, "error", err)
 		return nil, err
 	}
+	if msg.SenderID != userID {
+		return nil, ErrNotMessageSender
+	}
+	if msg.Type != models.MessageTypeText || msg.RemovedAt != nil {
+		return nil, ErrMessageNotEditable
+	}
 
-	s.log.Info("service: message fetched", "user_id", userID, "count", len(messages))
-	return messages, nil
+	if msg.Text != req.Text {
+		err := s.messages.Edit(ctx, msg, req.Text)
+		if errors.Is(err, repository.ErrMessageRemoved) {
+			return nil, ErrMessageNotEditable
+		}
+		if err != nil {
+			s.log.ErrorContext(ctx, "service: failed to edit message", "message_id", messageID, "error", err)
+			return nil, err
+		}
+		s.log.InfoContext(ctx, "service: message edited", "message_id", messageID, "chat_id", msg.ChatID)
+	}
+
+	res := toMessageResponse(msg)
+	s.events.Publish(participants(chat), EventMessageUpdated, res)
+	return &res, nil
+}
+
+// DeleteMessage hides the message for the caller (scope "me", any member) or
+// replaces it with a tombstone for the whole chat (scope "everyone", sender only).
+func (s *messageService) DeleteMessage(ctx context.Context, userID, messageID uint, scope string) error {
+	ctx, span := tracing.Start(ctx, "MessageService.DeleteMessage")
+	defer span.End()
+
+	if scope == "" {
+		scope = DeleteForMe
+	}
+	if scope != DeleteForMe && scope != DeleteForEveryone {
+		return ErrInvalidDeleteScope
+	}
+
+	msg, chat, err := s.loadMessage(ctx, userID, messageID)
+	if err != nil {
+		return err
+	}
+
+	if scope == DeleteForMe {
+		if err := s.messages.Hide(ctx, messageID, userID); err != nil {
+			s.log.ErrorContext(ctx, "service: failed to hide message", "message_id", messageID, "user_id", userID, "error", err)
+			return err
+		}
+		s.events.Publish([]uint{userID}, EventMessageDeleted, toMessageResponse(msg))
+		return nil
+	}
+
+	if msg.SenderID != userID {
+		return ErrNotMessageSender
+	}
+	if msg.Type != models.MessageTypeText {
+		return ErrMessageNotEditable
+	}
+	if msg.RemovedAt == nil {
+		attachments := msg.Attachments
+		err := s.messages.RemoveForAll(ctx, msg)
+		if errors.Is(err, repository.ErrMessageRemoved) {
+			// A concurrent request removed it and announces that itself.
+			return nil
+		}
+		if err != nil {
+			s.log.ErrorContext(ctx, "service: failed to remove message", "message_id", messageID, "error", err)
+			return err
+		}
+		s.log.InfoContext(ctx, "service: message removed for everyone", "message_id", messageID, "chat_id", msg.ChatID)
+		if msg.ThreadRootID != nil {
+			s.publishThreadRoot(ctx, chat, *msg.ThreadRootID)
+		}
+		// The records are gone already; content left behind is only garbage.
+		for _, a := range attachments {
+			keys := []string{a.StorageKey}
+			for _, t := range a.Thumbnails {
+				keys = append(keys, t.StorageKey)
+			}
+			for _, key := range keys {
+				if err := s.blobs.Delete(context.WithoutCancel(ctx), key); err != nil {
+					s.log.ErrorContext(ctx, "service: failed to delete attachment content", "attachment_id", a.ID, "error", err)
+				}
+			}
+		}
+	}
+
+	s.events.Publish(participants(chat), EventMessageDeleted, toMessageResponse(msg))
+	return nil
+}
+
+func (s *messageService) ListEdits(ctx context.Context, userID, messageID uint) ([]dto.MessageEditResponse, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.ListEdits")
+	defer span.End()
+
+	if _, _, err := s.loadMessage(ctx, userID, messageID); err != nil {
+		return nil, err
+	}
+
+	edits, err := s.messages.ListEdits(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.MessageEditResponse, 0, len(edits))
+	for _, e := range edits {
+		res = append(res, dto.MessageEditResponse{Text: e.Text, ReplacedAt: e.CreatedAt})
+	}
+	return res, nil
+}
+
+// ListThread returns a page of the replies to rootID, paged like chat
+// history, with the root message itself.
+func (s *messageService) ListThread(ctx context.Context, userID, rootID uint, query dto.MessageHistoryQuery) (*dto.ThreadPage, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.ListThread")
+	defer span.End()
+
+	page, err := pageQuery(query)
+	page.ViewerID = userID
+	if err != nil {
+		return nil, err
+	}
+
+	root, _, err := s.loadMessage(ctx, userID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	if root.ThreadRootID != nil {
+		return nil, ErrInvalidThreadRoot
+	}
+
+	limit := page.Limit
+	page.Limit++
+	replies, err := s.messages.ListThread(ctx, rootID, page)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &dto.ThreadPage{
+		Root:     toMessageResponse(root),
+		Messages: make([]dto.MessageResponse, 0, min(len(replies), limit)),
+	}
+	if len(replies) > limit {
+		replies = replies[:limit]
+		last := replies[limit-1]
+		res.NextCursor = encodeCursor(last.CreatedAt, last.ID)
+	}
+	for i := range replies {
+		res.Messages = append(res.Messages, toMessageResponse(&replies[i]))
+	}
+
+	roots := []dto.MessageResponse{res.Root}
+	if err := s.countThreadUnread(ctx, userID, roots); err != nil {
+		return nil, err
+	}
+	res.Root = roots[0]
+	return res, nil
+}
+
+// MarkThreadRead marks the replies to rootID up to req.MessageID (or the
+// newest one when it is zero) as read by userID. Only the user's own
+// connections are told, since thread reads are not shown to others.
+func (s *messageService) MarkThreadRead(ctx context.Context, userID, rootID uint, req dto.MarkReadRequest) (*dto.ThreadReadReceipt, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.MarkThreadRead")
+	defer span.End()
+
+	root, _, err := s.loadMessage(ctx, userID, rootID)
+	if err != nil {
+		return nil, err
+	}
+	if root.ThreadRootID != nil {
+		return nil, ErrInvalidThreadRoot
+	}
+
+	receipt := &dto.ThreadReadReceipt{RootMessageID: rootID, UserID: userID}
+	messageID := req.MessageID
+	if messageID == 0 {
+		newest, err := s.messages.ListThread(ctx, rootID, repository.MessageQuery{ViewerID: userID, Limit: 1})
+		if err != nil {
+			return nil, err
+		}
+		if len(newest) == 0 {
+			return receipt, nil
+		}
+		messageID = newest[0].ID
+	} else {
+		msg, err := s.messages.GetByID(ctx, messageID)
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrMessageNotFound
+			}
+			return nil, err
+		}
+		if msg.ThreadRootID == nil || *msg.ThreadRootID != rootID {
+			return nil, ErrMessageNotFound
+		}
+	}
+
+	receipt.LastReadMessageID, err = s.messages.MarkThreadRead(ctx, rootID, userID, messageID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.events.Publish([]uint{userID}, EventThreadRead, receipt)
+	return receipt, nil
+}
+
+// publishThreadRoot tells the chat about a root whose thread summary changed.
+// The message itself was already stored, so failures are only logged.
+func (s *messageService) publishThreadRoot(ctx context.Context, chat *models.Chat, rootID uint) {
+	root, err := s.messages.GetByID(ctx, rootID)
+	if err != nil {
+		s.log.ErrorContext(ctx, "service: failed to reload thread root", "message_id", rootID, "error", err)
+		return
+	}
+	s.events.Publish(participants(chat), EventMessageUpdated, toMessageResponse(root))
+}
+
+// countThreadUnread fills the viewer's unread count into the thread summary
+// of the given messages, with one query for all of them.
+func (s *messageService) countThreadUnread(ctx context.Context, userID uint, messages []dto.MessageResponse) error {
+	var roots []uint
+	for _, m := range messages {
+		if m.Thread != nil {
+			roots = append(roots, m.ID)
+		}
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+
+	counts, err := s.messages.ThreadUnreadCounts(ctx, userID, roots)
+	if err != nil {
+		return err
+	}
+	for i := range messages {
+		if messages[i].Thread != nil {
+			unread := counts[messages[i].ID]
+			messages[i].Thread.UnreadCount = &unread
+		}
+	}
+	return nil
+}
+
+// isThreadRoot reports whether root can start a thread in chatID: it must be
+// a top-level message of that chat that still exists.
+func isThreadRoot(root *models.Message, chatID uint) bool {
+	return root.ChatID == chatID && root.ThreadRootID == nil && root.RemovedAt == nil
+}
+
+// SearchMessages runs a full-text search over the chats the user belongs to,
+// best matches first.
+func (s *messageService) SearchMessages(ctx context.Context, userID uint, query dto.MessageSearchQuery) (*dto.MessageSearchPage, error) {
+	ctx, span := tracing.Start(ctx, "MessageService.SearchMessages")
+	defer span.End()
+
+	text := strings.TrimSpace(query.Q)
+	if text == "" {
+		return nil, apperr.Field("q", "required")
+	}
+
+	search := repository.SearchQuery{
+		ViewerID: userID,
+		Text:     text,
+		ChatID:   query.ChatID,
+		SenderID: query.SenderID,
+		From:     query.From,
+		Before:   query.Before,
+		Limit:    clampPageSize(query.Limit),
+	}
+	if !query.From.IsZero() && !query.Before.IsZero() && !query.From.Before(query.Before) {
+		return nil, apperr.Field("before", "invalid")
+	}
+	if query.Cursor != "" {
+		after, err := decodeSearchCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		search.After = after
+	}
+
+	// Searching a chat the user cannot see answers like reading it would.
+	if query.ChatID != 0 {
+		if _, err := s.access.RequireMember(ctx, query.ChatID, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	limit := search.Limit
+	search.Limit++
+	hits, err := s.messages.Search(ctx, search)
+	if err != nil {
+		s.log.ErrorContext(ctx, "service: message search failed", "user_id", userID, "error", err)
+		return nil, err
+	}
+
+	res := &dto.MessageSearchPage{Results: make([]dto.MessageSearchResult, 0, min(len(hits), limit))}
+	if len(hits) > limit {
+		hits = hits[:limit]
+		last := hits[limit-1]
+		res.NextCursor = encodeSearchCursor(last.Rank, last.CreatedAt, last.ID)
+	}
+	for i := range hits {
+		res.Results = append(res.Results, dto.MessageSearchResult{
+			Message: toMessageResponse(&hits[i].Message),
+			Snippet: highlight(hits[i].Snippet),
+			Rank:    hits[i].Rank,
+		})
+	}
+	return res, nil
+}
+
+// highlight escapes a search snippet and turns the repository's match markers
+// into <mark> tags, so clients can render it as HTML safely.
+func highlight(snippet string) string {
+	return strings.NewReplacer(
+		repository.HighlightStart, "<mark>",
+		repository.HighlightStop, "</mark>",
+	).Replace(html.EscapeString(snippet))
+}
+
+// loadMessage returns a message and its chat if userID may see it. Messages in
+// chats the user does not belong to are reported as missing.
+func (s *messageService) loadMessage(ctx context.Context, userID, messageID uint) (*models.Message, *models.Chat, error) {
+	msg, err := s.messages.GetByID(ctx, messageID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, err
+	}
+
+	chat, err := s.access.RequireMember(ctx, msg.ChatID, userID)
+	if err != nil {
+		if errors.Is(err, ErrNotChatMember) || errors.Is(err, ErrChatNotFound) {
+			return nil, nil, ErrMessageNotFound
+		}
+		return nil, nil, err
+	}
+	return msg, chat, nil
+}
+
+// directPartnerReadPointer returns how far the other member of a direct chat
+// has read, with zero meaning nothing yet. It is nil for group chats.
+func directPartnerReadPointer(chat *models.Chat, userID uint) *uint {
+	if chat.Type != models.ChatTypeDirect {
+		return nil
+	}
+	var pointer uint
+	for _, m := range chat.Members {
+		if m.UserID != userID && m.LastReadMessageID != nil {
+			pointer = *m.LastReadMessageID
+		}
+	}
+	return &pointer
+}
+
+func pageQuery(query dto.MessageHistoryQuery) (repository.MessageQuery, error) {
+	page := repository.MessageQuery{Limit: clampPageSize(query.Limit)}
+
+	if query.Before != "" && query.After != "" {
+		return page, ErrConflictingPage
+	}
+
+	var err error
+	if query.Before != "" {
+		page.Before, err = decodeCursor(query.Before)
+	}
+	if query.After != "" {
+		page.After, err = decodeCursor(query.After)
+	}
+	return page, err
+}
+
+func toMessageResponse(m *models.Message) dto.MessageResponse {
+	return dto.MessageResponse{
+		ID:        m.ID,
+		ChatID:    m.ChatID,
+		SenderID:  m.SenderID,
+		Type:      m.Type,
+		Text:      m.Text,
+		CreatedAt: m.CreatedAt,
+		EditedAt:  m.EditedAt,
+		Deleted:   m.RemovedAt != nil,
+
+		Attachments: toAttachmentResponses(m.Attachments),
+		ReplyTo:     toMessagePreview(m),
+
+		ThreadRootID: m.ThreadRootID,
+		Thread:       toThreadSummary(m),
+	}
+}
+
+func toThreadSummary(m *models.Message) *dto.ThreadSummary {
+	if m.ReplyCount == 0 || m.LastReplyAt == nil {
+		return nil
+	}
+	return &dto.ThreadSummary{ReplyCount: m.ReplyCount, LastReplyAt: *m.LastReplyAt}
+}
+
+// toMessagePreview describes the message m replies to. A quoted message that
+// no longer exists is shown as deleted.
+func toMessagePreview(m *models.Message) *dto.MessagePreview {
+	if m.ReplyToMessageID == nil {
+		return nil
+	}
+	quoted := m.ReplyTo
+	if quoted == nil {
+		return &dto.MessagePreview{ID: *m.ReplyToMessageID, Deleted: true}
+	}
+	preview := &dto.MessagePreview{
+		ID:       quoted.ID,
+		SenderID: quoted.SenderID,
+		Type:     quoted.Type,
+		Deleted:  quoted.RemovedAt != nil,
+	}
+	if !preview.Deleted {
+		preview.Text = excerpt(quoted.Text, previewLength)
+	}
+	return preview
+}
+
+// excerpt cuts text to at most n characters, marking the cut with an
+// ellipsis.
+func excerpt(text string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+	return strings.TrimRight(string(runes[:n-1]), " \n") + "…"
+}
+
+func toAttachmentResponses(attachments []models.Attachment) []dto.AttachmentResponse {
+	if len(attachments) == 0 {
+		return nil
+	}
+	res := make([]dto.AttachmentResponse, 0, len(attachments))
+	for i := range attachments {
+		res = append(res, toAttachmentResponse(&attachments[i]))
+	}
+	return res
+}
+
+// uniqueIDs drops repeated ids, keeping the order of first occurrences.
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	res := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			res = append(res, id)
+		}
+	}
+	return res
 }
@@ -0,0 +1,287 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+)
+
+// Users 1 and 2 share chat 10; user 3 is in chat 20 only.
+func newTestMessageService() (MessageService, *fakeMessageRepository, *fakeEvents) {
+	chats := &fakeChatRepository{chats: map[uint]*models.Chat{
+		10: testChat(10, models.ChatTypeDirect, 1, 2),
+		20: testChat(20, models.ChatTypeGroup, 3),
+	}}
+	messages := &fakeMessageRepository{messages: []models.Message{
+		{ChatID: 10, SenderID: 1, Text: "hi"},
+		{ChatID: 20, SenderID: 3, Text: "secret"},
+	}}
+	for i := range messages.messages {
+		messages.messages[i].ID = uint(i + 1)
+	}
+	events := &fakeEvents{}
+	return NewMessageService(messages, NewChatAccess(chats), events, nil, discardLog), messages, events
+}
+
+func TestCreateMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  uint
+		req     dto.CreateMessageRequest
+		wantErr error
+	}{
+		{"member", 1, dto.CreateMessageRequest{ChatID: 10, Text: "hello"}, nil},
+		{"member naming themselves", 2, dto.CreateMessageRequest{ChatID: 10, SenderID: 2, Text: "hello"}, nil},
+		{"spoofed sender", 1, dto.CreateMessageRequest{ChatID: 10, SenderID: 2, Text: "hello"}, ErrSenderMismatch},
+		{"spoofed sender of another chat", 3, dto.CreateMessageRequest{ChatID: 10, SenderID: 1, Text: "hello"}, ErrSenderMismatch},
+		{"non-member", 3, dto.CreateMessageRequest{ChatID: 10, Text: "hello"}, ErrNotChatMember},
+		{"missing chat", 1, dto.CreateMessageRequest{ChatID: 99, Text: "hello"}, ErrChatNotFound},
+		{"empty text", 1, dto.CreateMessageRequest{ChatID: 10}, ErrEmptyMessage},
+		{"no chat", 1, dto.CreateMessageRequest{Text: "hello"}, ErrInvalidChatID},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, repo, events := newTestMessageService()
+			before := len(repo.messages)
+
+			msg, err := svc.CreateMessage(context.Background(), tt.userID, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("CreateMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if len(repo.messages) != before || len(events.published) != 0 {
+					t.Fatalf("rejected message was stored or published")
+				}
+				return
+			}
+			if msg.SenderID != tt.userID || msg.ChatID != tt.req.ChatID {
+				t.Fatalf("message sender/chat = %d/%d, want %d/%d", msg.SenderID, msg.ChatID, tt.userID, tt.req.ChatID)
+			}
+			if len(events.published) != 1 || events.published[0] != EventMessageCreated {
+				t.Fatalf("published %v, want one %s", events.published, EventMessageCreated)
+			}
+		})
+	}
+}
+
+func TestListMessages(t *testing.T) {
+	tests := []struct {
+		name     string
+		userID   uint
+		chatID   uint
+		wantErr  error
+		wantText []string
+	}{
+		{"member", 1, 10, nil, []string{"hi"}},
+		{"other member", 2, 10, nil, []string{"hi"}},
+		{"non-member", 1, 20, ErrNotChatMember, nil},
+		{"missing chat", 1, 99, ErrChatNotFound, nil},
+		{"no chat", 1, 0, ErrInvalidChatID, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, _, _ := newTestMessageService()
+
+			page, err := svc.ListMessages(context.Background(), tt.userID, tt.chatID, dto.MessageHistoryQuery{})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ListMessages() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil {
+				if page != nil {
+					t.Fatalf("ListMessages() returned a page along with %v", err)
+				}
+				return
+			}
+			var got []string
+			for _, m := range page.Messages {
+				got = append(got, m.Text)
+			}
+			if len(got) != len(tt.wantText) || got[0] != tt.wantText[0] {
+				t.Fatalf("messages = %q, want %q", got, tt.wantText)
+			}
+		})
+	}
+}
+
+func TestRequireMember(t *testing.T) {
+	access := NewChatAccess(&fakeChatRepository{chats: map[uint]*models.Chat{
+		10: testChat(10, models.ChatTypeGroup, 1, 2),
+	}})
+	tests := []struct {
+		name    string
+		chatID  uint
+		userID  uint
+		wantErr error
+	}{
+		{"member", 10, 2, nil},
+		{"non-member", 10, 3, ErrNotChatMember},
+		{"anonymous", 10, 0, ErrNotChatMember},
+		{"missing chat", 11, 1, ErrChatNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chat, err := access.RequireMember(context.Background(), tt.chatID, tt.userID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("RequireMember() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && chat.ID != tt.chatID {
+				t.Fatalf("RequireMember() chat = %d, want %d", chat.ID, tt.chatID)
+			}
+		})
+	}
+}
+
+// A reply keeps the quoted message for the response, but only the reply
+// itself is written.
+func TestCreateMessageKeepsQuoteUnsaved(t *testing.T) {
+	db, mock, _ := newCountingDB(t)
+	quoted := &models.Message{ChatID: 10, SenderID: 2, Type: models.MessageTypeText, Text: "quoted",
+		Attachments: []models.Attachment{{ChatID: 10, UploaderID: 2}}}
+	quoted.ID = 100
+	msg := &models.Message{ChatID: 10, SenderID: 1, Type: models.MessageTypeText, Text: "reply",
+		ReplyToMessageID: &quoted.ID, ReplyTo: quoted}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(`INSERT INTO "messages"`)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(101))
+	mock.ExpectCommit()
+
+	messages := repository.NewMessageRepository(db, discardLog, "english")
+	if err := messages.Create(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Removing for everyone locks the message before dropping its revisions,
+// and leaves a message removed meanwhile alone.
+func TestRemoveMessageForAllLocks(t *testing.T) {
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	lock := regexp.QuoteMeta(`SELECT "id","removed_at" FROM "messages" WHERE "messages"."id" = $1`) + `.* FOR UPDATE`
+
+	t.Run("removes", func(t *testing.T) {
+		db, mock, _ := newCountingDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lock).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "removed_at"}).AddRow(1, nil))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "message_edits"`)).WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectExec(regexp.QuoteMeta(`DELETE FROM "attachments"`)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(`UPDATE "messages"`)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		msg := &models.Message{Text: "bye"}
+		msg.ID = 1
+		messages := repository.NewMessageRepository(db, discardLog, "english")
+		if err := messages.RemoveForAll(context.Background(), msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.RemovedAt == nil || msg.Text != "" {
+			t.Fatalf("message = %+v, want a tombstone", msg)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("already removed", func(t *testing.T) {
+		db, mock, _ := newCountingDB(t)
+		mock.ExpectBegin()
+		mock.ExpectQuery(lock).WithArgs(1, 1).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "removed_at"}).AddRow(1, at))
+		mock.ExpectRollback()
+
+		msg := &models.Message{Text: "bye"}
+		msg.ID = 1
+		messages := repository.NewMessageRepository(db, discardLog, "english")
+		if err := messages.RemoveForAll(context.Background(), msg); !errors.Is(err, repository.ErrMessageRemoved) {
+			t.Fatalf("RemoveForAll() error = %v, want %v", err, repository.ErrMessageRemoved)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// Search hits show their attachments and, when they quote a message, its
+// preview, each loaded for the whole page at once. A quoted message is
+// "deleted" only when it really is gone. Thread roots show their summary.
+func TestSearchMessagesHits(t *testing.T) {
+	db, mock, queries := newCountingDB(t)
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	hits := sqlmock.NewRows([]string{"id", "created_at", "chat_id", "sender_id", "type", "text", "reply_to_message_id", "reply_count", "last_reply_at", "rank", "snippet"})
+	for i, replyTo := range []any{100, 101, 102, nil} {
+		hits.AddRow(i+1, at, 10, 1, models.MessageTypeText, "found", replyTo, 0, nil, 0.5, "found")
+	}
+	// The last hit starts a thread.
+	hits.AddRow(5, at, 10, 1, models.MessageTypeText, "found", nil, 2, at, 0.5, "found")
+	mock.ExpectQuery(`WITH q AS .* m\.reply_count, m\.last_reply_at`).WillReturnRows(hits)
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "attachments" WHERE message_id IN`)).
+		WithArgs(1, 2, 3, 4, 5).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "chat_id", "message_id", "file_name"}).
+			AddRow(30, 10, 1, "a.jpg").
+			AddRow(31, 10, 1, "b.txt").
+			AddRow(32, 10, 5, "c.txt"))
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "attachment_thumbnails"`)).
+		WithArgs(30, 31, 32).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "attachment_id", "size"}).AddRow(1, 30, 320))
+	// 102 no longer exists.
+	mock.ExpectQuery(regexp.QuoteMeta(`FROM "messages" WHERE id IN`)).
+		WithArgs(100, 101, 102).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "sender_id", "type", "text", "removed_at"}).
+			AddRow(100, 2, models.MessageTypeText, "quoted", nil).
+			AddRow(101, 2, models.MessageTypeText, "removed", at))
+
+	messages := repository.NewMessageRepository(db, discardLog, "english")
+	svc := NewMessageService(messages, nil, &fakeEvents{}, nil, discardLog)
+	page, err := svc.SearchMessages(context.Background(), 1, dto.MessageSearchQuery{Q: "found"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*dto.MessagePreview{
+		{ID: 100, SenderID: 2, Type: models.MessageTypeText, Text: "quoted"},
+		{ID: 101, SenderID: 2, Type: models.MessageTypeText, Deleted: true},
+		{ID: 102, Deleted: true},
+		nil,
+		nil,
+	}
+	if len(page.Results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(page.Results), len(want))
+	}
+	for i, w := range want {
+		got := page.Results[i].Message.ReplyTo
+		if (got == nil) != (w == nil) || got != nil && *got != *w {
+			t.Errorf("result %d reply_to = %+v, want %+v", i, got, w)
+		}
+	}
+	if got := page.Results[4].Message.Thread; got == nil || got.ReplyCount != 2 || !got.LastReplyAt.Equal(at) {
+		t.Errorf("thread = %+v, want 2 replies at %v", got, at)
+	}
+	var attachments []string
+	for _, r := range page.Results {
+		for _, a := range r.Message.Attachments {
+			attachments = append(attachments, fmt.Sprintf("%d:%s:%d", *a.MessageID, a.FileName, len(a.Thumbnails)))
+		}
+	}
+	if got, want := strings.Join(attachments, " "), "1:a.jpg:1 1:b.txt:0 5:c.txt:0"; got != want {
+		t.Errorf("attachments = %q, want %q", got, want)
+	}
+	if got := queries.Load(); got != 4 {
+		t.Fatalf("search ran %d queries, want 4", got)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/DjMariarty/messenger/internal/media"
+	"github.com/DjMariarty/messenger/internal/metrics"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/storage"
+)
+
+// ThumbnailSizes bound the longer side of the thumbnails made for every
+// image: one for chat previews and one for full-screen viewing.
+var ThumbnailSizes = []int{320, 1280}
+
+const (
+	// maxThumbnailPixels guards against images that are small on disk but
+	// would take gigabytes once decoded.
+	maxThumbnailPixels = 50_000_000
+	thumbnailTimeout   = time.Minute
+	thumbnailQueueSize = 256
+	// thumbnailSweepInterval is how often images left pending, after a
+	// restart or a full queue, are picked up again.
+	thumbnailSweepInterval = time.Minute
+)
+
+// ThumbnailQueue schedules thumbnail generation for an uploaded image.
+type ThumbnailQueue interface {
+	Enqueue(attachmentID uint)
+}
+
+// Thumbnailer renders thumbnails of image attachments in the background. The
+// pending state in the database is the source of truth; the in-memory queue
+// only makes fresh uploads fast.
+type Thumbnailer struct {
+	attachments repository.AttachmentRepository
+	blobs       storage.Blob
+	workers     int
+	log         *slog.Logger
+
+	queue chan uint
+	// queued holds ids that are waiting or being processed, so a sweep does
+	// not hand the same image to two workers.
+	mu     sync.Mutex
+	queued map[uint]struct{}
+
+	// done is closed by Shutdown; stopped is closed when Run has returned.
+	done     chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func NewThumbnailer(attachments repository.AttachmentRepository, blobs storage.Blob, workers int, log *slog.Logger) *Thumbnailer {
+	return &Thumbnailer{
+		attachments: attachments,
+		blobs:       blobs,
+		workers:     workers,
+		log:         log,
+		queue:       make(chan uint, thumbnailQueueSize),
+		queued:      make(map[uint]struct{}),
+		done:        make(chan struct{}),
+		stopped:     make(chan struct{}),
+	}
+}
+
+// Enqueue never blocks; when the queue is full the image stays pending until
+// the next sweep.
+func (t *Thumbnailer) Enqueue(attachmentID uint) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.queued[attachmentID]; ok {
+		return
+	}
+	select {
+	case t.queue <- attachmentID:
+		t.queued[attachmentID] = struct{}{}
+	default:
+		t.log.Warn("thumbnailer: queue full, deferring", slog.Uint64("attachment_id", uint64(attachmentID)))
+	}
+}
+
+// Run processes the queue until Shutdown is called.
+func (t *Thumbnailer) Run() {
+	defer close(t.stopped)
+
+	var wg sync.WaitGroup
+	for range t.workers {
+		wg.Go(func() {
+			for {
+				select {
+				case <-t.done:
+					return
+				case id := <-t.queue:
+					t.process(id)
+					t.mu.Lock()
+					delete(t.queued, id)
+					t.mu.Unlock()
+				}
+			}
+		})
+	}
+
+	sweep := time.NewTicker(thumbnailSweepInterval)
+	defer sweep.Stop()
+	for {
+		t.sweep()
+		select {
+		case <-t.done:
+			wg.Wait()
+			t.log.Info("thumbnailer: stopped")
+			return
+		case <-sweep.C:
+		}
+	}
+}
+
+// Shutdown stops Run and waits for thumbnails being rendered to be saved,
+// until ctx expires. Queued images stay pending for the next start.
+func (t *Thumbnailer) Shutdown(ctx context.Context) error {
+	t.stopOnce.Do(func() { close(t.done) })
+
+	select {
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Running reports whether Run is still processing images.
+func (t *Thumbnailer) Running() bool {
+	select {
+	case <-t.done:
+		return false
+	default:
+		return true
+	}
+}
+
+func (t *Thumbnailer) sweep() {
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+	defer cancel()
+
+	ids, err := t.attachments.ListPendingThumbnails(ctx, thumbnailQueueSize)
+	if err != nil {
+		return
+	}
+	for _, id := range ids {
+		t.Enqueue(id)
+	}
+}
+
+func (t *Thumbnailer) process(id uint) {
+	ctx, cancel := context.WithTimeout(context.Background(), thumbnailTimeout)
+	defer cancel()
+	log := t.log.With(slog.Uint64("attachment_id", uint64(id)))
+
+	att, err := t.attachments.GetByID(ctx, id)
+	if err != nil {
+		// Deleted in the meantime, or the database is down and a later
+		// sweep will retry.
+		return
+	}
+	if att.ThumbnailState != models.ThumbnailPending {
+		return
+	}
+
+	thumbnails, err := t.render(ctx, att)
+	if errors.Is(err, errUnusableImage) {
+		log.WarnContext(ctx, "thumbnailer: cannot render image", slog.Any("error", err))
+		metrics.ThumbnailJobs.WithLabelValues(models.ThumbnailFailed).Inc()
+		_ = t.attachments.SetThumbnailState(ctx, id, models.ThumbnailFailed)
+		return
+	}
+	if err == nil {
+		err = t.attachments.SaveThumbnails(ctx, id, thumbnails)
+	}
+	if err != nil {
+		// Storage or database trouble: stay pending and retry on a sweep.
+		log.ErrorContext(ctx, "thumbnailer: failed", slog.Any("error", err))
+		return
+	}
+	metrics.ThumbnailJobs.WithLabelValues(models.ThumbnailReady).Inc()
+	log.InfoContext(ctx, "thumbnailer: thumbnails ready", slog.Int("count", len(thumbnails)))
+}
+
+var errUnusableImage = errors.New("unusable image")
+
+// render makes and stores a thumbnail for each size up to the one the image
+// already fits in; larger sizes would just repeat it.
+func (t *Thumbnailer) render(ctx context.Context, att *models.Attachment) ([]models.AttachmentThumbnail, error) {
+	content, err := t.blobs.Open(ctx, att.StorageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	src, orientation, err := decodeImage(content, att.MimeType)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errUnusableImage, err)
+	}
+
+	mimeType, ext, encode := "image/jpeg", "jpg", media.EncodeJPEG
+	if media.Transparent(src) {
+		mimeType, ext, encode = "image/webp", "webp", media.EncodeWebP
+	}
+
+	var thumbnails []models.AttachmentThumbnail
+	b := src.Bounds()
+	for _, size := range ThumbnailSizes {
+		img := media.Thumbnail(src, size, orientation)
+		data, err := encode(img)
+		if err != nil {
+			return nil, err
+		}
+		thumb := models.AttachmentThumbnail{
+			AttachmentID: att.ID,
+			Size:         size,
+			StorageKey:   fmt.Sprintf("%s.thumb%d.%s", att.StorageKey, size, ext),
+			MimeType:     mimeType,
+			Width:        img.Bounds().Dx(),
+			Height:       img.Bounds().Dy(),
+			Bytes:        int64(len(data)),
+		}
+		if err := t.blobs.Put(ctx, thumb.StorageKey, bytes.NewReader(data), thumb.Bytes, mimeType); err != nil {
+			return nil, err
+		}
+		thumbnails = append(thumbnails, thumb)
+		if max(b.Dx(), b.Dy()) <= size {
+			break
+		}
+	}
+	return thumbnails, nil
+}
+
+// decodeImage decodes an image after checking that its size is sane. For
+// JPEG it also reports the EXIF orientation.
+func decodeImage(content io.ReadSeeker, mimeType string) (image.Image, int, error) {
+	orientation := 1
+	if mimeType == "image/jpeg" {
+		header, err := media.ReadJPEGHeader(content)
+		if err != nil {
+			return nil, 0, err
+		}
+		orientation = header.Orientation
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	cfg, _, err := image.DecodeConfig(content)
+	if err != nil {
+		return nil, 0, err
+	}
+	if cfg.Width*cfg.Height > maxThumbnailPixels {
+		return nil, 0, fmt.Errorf("%dx%d is too large", cfg.Width, cfg.Height)
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	img, _, err := image.Decode(content)
+	if err != nil {
+		return nil, 0, err
+	}
+	return img, orientation, nil
+}
@@ -0,0 +1,282 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/DjMariarty/messenger/internal/auth"
+	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/models"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/tracing"
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInvalidRefreshToken = apperr.New(apperr.Unauthenticated, "invalid_refresh_token", "invalid refresh token")
+	ErrRefreshTokenReused  = apperr.New(apperr.Unauthenticated, "refresh_token_reused", "refresh token reuse detected")
+	ErrSessionNotFound     = apperr.New(apperr.NotFound, "session_not_found", "session not found")
+	ErrSessionRequired     = apperr.New(apperr.Unauthenticated, "session_required", "sign in again to manage your sessions")
+)
+
+// TokenService owns login sessions: it issues access/refresh token pairs,
+// rotates refresh tokens and answers revocation checks for
+// middleware.AuthRequired.
+type TokenService interface {
+	Issue(ctx context.Context, user *models.User, client dto.ClientInfo) (*dto.LoginResponse, error)
+	Refresh(ctx context.Context, refreshToken string, client dto.ClientInfo) (*dto.LoginResponse, error)
+	Logout(ctx context.Context, claims *auth.Claims) error
+	IsRevoked(ctx context.Context, claims *auth.Claims) (bool, error)
+
+	ListSessions(ctx context.Context, claims *auth.Claims) ([]dto.SessionResponse, error)
+	RevokeSession(ctx context.Context, claims *auth.Claims, sessionID uint) error
+	RevokeOtherSessions(ctx context.Context, claims *auth.Claims) error
+}
+
+type tokenService struct {
+	db     *gorm.DB
+	tokens repository.TokenRepository
+	users  repository.UserRepository
+	log    *slog.Logger
+}
+
+func NewTokenService(db *gorm.DB, tokens repository.TokenRepository, users repository.UserRepository, log *slog.Logger) TokenService {
+	return &tokenService{db: db, tokens: tokens, users: users, log: log}
+}
+
+// Issue starts a new session for user.
+func (s *tokenService) Issue(ctx context.Context, user *models.User, client dto.ClientInfo) (*dto.LoginResponse, error) {
+	ctx, span := tracing.Start(ctx, "TokenService.Issue")
+	defer span.End()
+
+	var res *dto.LoginResponse
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tokens := s.tokens.WithTx(tx)
+
+		now := time.Now()
+		session := models.Session{
+			UserID:     user.ID,
+			UserAgent:  client.UserAgent,
+			IP:         client.IP,
+			LastSeenAt: now,
+			ExpiresAt:  now.Add(auth.RefreshTTL()),
+		}
+		if err := tokens.CreateSession(ctx, &session); err != nil {
+			return err
+		}
+
+		var err error
+		res, err = s.issue(ctx, tokens, user, session.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Refresh exchanges a refresh token for a new pair in the same session.
+// Presenting a token that was already rotated or revoked means it leaked,
+// so the whole session is revoked.
+func (s *tokenService) Refresh(ctx context.Context, refreshToken string, client dto.ClientInfo) (*dto.LoginResponse, error) {
+	ctx, span := tracing.Start(ctx, "TokenService.Refresh")
+	defer span.End()
+
+	current, err := s.tokens.GetRefreshTokenByHash(ctx, auth.HashRefreshToken(refreshToken))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	if current.UsedAt != nil || current.RevokedAt != nil {
+		return nil, s.reuseDetected(ctx, current)
+	}
+	if time.Now().After(current.ExpiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	// Reload the user so that the new access token carries the current
+	// profile locale.
+	user, err := s.users.GetByID(ctx, current.UserID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidRefreshToken
+		}
+		return nil, err
+	}
+
+	var res *dto.LoginResponse
+	err = s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		tokens := s.tokens.WithTx(tx)
+
+		ok, err := tokens.MarkRefreshTokenUsed(ctx, current.ID)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrRefreshTokenReused
+		}
+
+		res, err = s.issue(ctx, tokens, user, current.SessionID)
+		if err != nil {
+			return err
+		}
+		return tokens.TouchSession(ctx, current.SessionID, client.UserAgent, client.IP, res.RefreshExpiresAt)
+	})
+	if errors.Is(err, ErrRefreshTokenReused) {
+		// Lost a race with another refresh of the same token.
+		return nil, s.reuseDetected(ctx, current)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.log.InfoContext(ctx, "token service: refreshed",
+		slog.Uint64("user_id", uint64(current.UserID)),
+		slog.Uint64("session_id", uint64(current.SessionID)),
+	)
+	return res, nil
+}
+
+// Logout revokes the presented access token and ends its session.
+func (s *tokenService) Logout(ctx context.Context, claims *auth.Claims) error {
+	ctx, span := tracing.Start(ctx, "TokenService.Logout")
+	defer span.End()
+
+	// Tokens issued before jti was introduced cannot be listed; they simply expire.
+	if claims.ID != "" && claims.ExpiresAt != nil {
+		if err := s.tokens.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time); err != nil {
+			return err
+		}
+	}
+
+	if claims.SessionID != 0 {
+		if err := s.tokens.RevokeSession(ctx, claims.SessionID); err != nil {
+			return err
+		}
+	}
+
+	s.log.InfoContext(ctx, "token service: logged out",
+		slog.Uint64("user_id", uint64(claims.UserID)),
+		slog.Uint64("session_id", uint64(claims.SessionID)),
+	)
+	return nil
+}
+
+func (s *tokenService) IsRevoked(ctx context.Context, claims *auth.Claims) (bool, error) {
+	ctx, span := tracing.Start(ctx, "TokenService.IsRevoked")
+	defer span.End()
+
+	return s.tokens.IsRevoked(ctx, claims.ID, claims.SessionID)
+}
+
+func (s *tokenService) ListSessions(ctx context.Context, claims *auth.Claims) ([]dto.SessionResponse, error) {
+	ctx, span := tracing.Start(ctx, "TokenService.ListSessions")
+	defer span.End()
+
+	sessions, err := s.tokens.ListActiveSessions(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]dto.SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		res = append(res, dto.SessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == claims.SessionID,
+		})
+	}
+	return res, nil
+}
+
+func (s *tokenService) RevokeSession(ctx context.Context, claims *auth.Claims, sessionID uint) error {
+	ctx, span := tracing.Start(ctx, "TokenService.RevokeSession")
+	defer span.End()
+
+	session, err := s.tokens.GetSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSessionNotFound
+		}
+		return err
+	}
+	if session.UserID != claims.UserID {
+		return ErrSessionNotFound
+	}
+
+	if err := s.tokens.RevokeSession(ctx, sessionID); err != nil {
+		return err
+	}
+
+	s.log.InfoContext(ctx, "token service: session revoked",
+		slog.Uint64("user_id", uint64(claims.UserID)),
+		slog.Uint64("session_id", uint64(sessionID)),
+	)
+	return nil
+}
+
+func (s *tokenService) RevokeOtherSessions(ctx context.Context, claims *auth.Claims) error {
+	ctx, span := tracing.Start(ctx, "TokenService.RevokeOtherSessions")
+	defer span.End()
+
+	// Tokens issued before sessions existed name none, and keeping
+	// session 0 would revoke every session including the caller's.
+	if claims.SessionID == 0 {
+		return ErrSessionRequired
+	}
+
+	if err := s.tokens.RevokeOtherSessions(ctx, claims.UserID, claims.SessionID); err != nil {
+		return err
+	}
+
+	s.log.InfoContext(ctx, "token service: other sessions revoked",
+		slog.Uint64("user_id", uint64(claims.UserID)),
+		slog.Uint64("session_id", uint64(claims.SessionID)),
+	)
+	return nil
+}
+
+func (s *tokenService) issue(ctx context.Context, tokens repository.TokenRepository, user *models.User, sessionID uint) (*dto.LoginResponse, error) {
+	access, claims, err := auth.GenerateToken(user.ID, sessionID, user.Locale)
+	if err != nil {
+		return nil, err
+	}
+
+	refresh, hash := auth.NewRefreshToken()
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		SessionID: sessionID,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(auth.RefreshTTL()),
+	}
+	if err := tokens.CreateRefreshToken(ctx, &record); err != nil {
+		return nil, err
+	}
+
+	return &dto.LoginResponse{
+		Token:            access,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: record.ExpiresAt,
+	}, nil
+}
+
+func (s *tokenService) reuseDetected(ctx context.Context, token *models.RefreshToken) error {
+	s.log.WarnContext(ctx, "token service: refresh token reuse, revoking session",
+		slog.Uint64("user_id", uint64(token.UserID)),
+		slog.Uint64("session_id", uint64(token.SessionID)),
+	)
+	if err := s.tokens.RevokeSession(ctx, token.SessionID); err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
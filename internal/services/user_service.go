@@ -1,53 +1,67 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"log/slog"
 
-	"github.com/DjMariarty/messenger/internal/auth"
+	"github.com/DjMariarty/messenger/internal/apperr"
 	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/i18n"
+	"github.com/DjMariarty/messenger/internal/metrics"
 	"github.com/DjMariarty/messenger/internal/models"
 	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/tracing"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
 var (
-	ErrUserNotFound       = errors.New("пользователь не найден")
-	ErrInvalidCredentials = errors.New("неверный email или пароль")
+	ErrUserNotFound       = apperr.New(apperr.NotFound, "user_not_found", "user not found")
+	ErrInvalidCredentials = apperr.New(apperr.Unauthenticated, "invalid_credentials", "invalid email or password")
+	ErrEmailTaken         = apperr.New(apperr.Conflict, "email_taken", "a user with this email already exists")
+	ErrUnsupportedLocale  = apperr.New(apperr.Invalid, "unsupported_locale", "locale is not supported")
 )
 
 type UserService interface {
-	RegisterUser(req dto.RegisterRequest) (*models.User, error)
+	RegisterUser(ctx context.Context, req dto.RegisterRequest) (*models.User, error)
 
-	LoginUser(data dto.LoginRequest) (string, error)
+	LoginUser(ctx context.Context, data dto.LoginRequest, client dto.ClientInfo) (*dto.LoginResponse, error)
 
-	GetByID(id uint) (*models.User, error)
+	GetByID(ctx context.Context, id uint) (*models.User, error)
+
+	UpdateProfile(ctx context.Context, id uint, req dto.UpdateProfileRequest) (*models.User, error)
 }
 
 type userService struct {
-	users repository.UserRepository
-	db    *gorm.DB
-	log   *slog.Logger
+	users  repository.UserRepository
+	tokens TokenService
+	db     *gorm.DB
+	log    *slog.Logger
 }
 
 func NewUserService(
 	db *gorm.DB,
 	users repository.UserRepository,
+	tokens TokenService,
 	log *slog.Logger,
 
 ) UserService {
 	return &userService{
-		db:    db,
-		users: users,
-		log:   log,
+		db:     db,
+		users:  users,
+		tokens: tokens,
+		log:    log,
 	}
 }
 
-func (s *userService) RegisterUser(req dto.RegisterRequest) (*models.User, error) {
-	s.log.Info("user service: register started", slog.String("email", req.Email))
+func (s *userService) RegisterUser(ctx context.Context, req dto.RegisterRequest) (*models.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.RegisterUser")
+	defer span.End()
+
+	s.log.InfoContext(ctx, "user service: register started", slog.String("email", req.Email))
 	if err := s.validateUserRegister(req); err != nil {
-		s.log.Warn("user service: register validation failed",
+		s.log.WarnContext(ctx, "user service: register validation failed",
 			slog.String("email", req.Email),
 			slog.String("reason", err.Error()),
 		)
@@ -56,15 +70,15 @@ func (s *userService) RegisterUser(req dto.RegisterRequest) (*models.User, error
 
 	var createdUser models.User
 
-	err := s.db.Transaction(func(tx *gorm.DB) error {
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
 		var existing models.User
 		if err := tx.Where("email = ?", req.Email).First(&existing).Error; err == nil {
-			s.log.Warn("user service: register failed - email already exists",
+			s.log.WarnContext(ctx, "user service: register failed - email already exists",
 				slog.String("email", req.Email),
 			)
-			return errors.New("пользователь с таким email уже существует")
+			return ErrEmailTaken
 		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-			s.log.Error("user service: register failed - db error on email check",
+			s.log.ErrorContext(ctx, "user service: register failed - db error on email check",
 				slog.String("email", req.Email),
 				slog.Any("error", err),
 			)
@@ -73,7 +87,7 @@ func (s *userService) RegisterUser(req dto.RegisterRequest) (*models.User, error
 
 		hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 		if err != nil {
-			s.log.Error("user service: register failed - bcrypt error",
+			s.log.ErrorContext(ctx, "user service: register failed - bcrypt error",
 				slog.String("email", req.Email),
 				slog.Any("error", err),
 			)
@@ -84,10 +98,11 @@ func (s *userService) RegisterUser(req dto.RegisterRequest) (*models.User, error
 			Name:         req.Name,
 			Email:        req.Email,
 			PasswordHash: string(hash),
+			Locale:       req.Locale,
 		}
 
 		if err := tx.Create(&createdUser).Error; err != nil {
-			s.log.Error("user service: register failed - create user error",
+			s.log.ErrorContext(ctx, "user service: register failed - create user error",
 				slog.String("email", req.Email),
 				slog.Any("error", err),
 			)
@@ -102,7 +117,7 @@ func (s *userService) RegisterUser(req dto.RegisterRequest) (*models.User, error
 		return nil, err
 	}
 
-	s.log.Info("user service: register success",
+	s.log.InfoContext(ctx, "user service: register success",
 		slog.Uint64("user_id", uint64(createdUser.ID)),
 		slog.String("email", createdUser.Email),
 	)
@@ -110,71 +125,115 @@ func (s *userService) RegisterUser(req dto.RegisterRequest) (*models.User, error
 	return &createdUser, nil
 }
 
-func (s *userService) LoginUser(req dto.LoginRequest) (string, error) {
-	s.log.Info("user service: login started", slog.String("email", req.Email))
+func (s *userService) LoginUser(ctx context.Context, req dto.LoginRequest, client dto.ClientInfo) (*dto.LoginResponse, error) {
+	ctx, span := tracing.Start(ctx, "UserService.LoginUser")
+	defer span.End()
+
+	s.log.InfoContext(ctx, "user service: login started", slog.String("email", req.Email))
 
-	user, err := s.users.GetByEmail(req.Email)
+	user, err := s.users.GetByEmail(ctx, req.Email)
 	if err != nil {
-		s.log.Warn("user service: login failed - invalid credentials",
+		s.log.WarnContext(ctx, "user service: login failed - invalid credentials",
 			slog.String("email", req.Email),
 		)
-		return "", ErrInvalidCredentials
+		metrics.Logins.WithLabelValues("failure").Inc()
+		return nil, ErrInvalidCredentials
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
-		s.log.Warn("user service: login failed - invalid credentials",
+		s.log.WarnContext(ctx, "user service: login failed - invalid credentials",
 			slog.String("email", req.Email),
 			slog.Uint64("user_id", uint64(user.ID)),
 		)
-		return "", ErrInvalidCredentials
+		metrics.Logins.WithLabelValues("failure").Inc()
+		return nil, ErrInvalidCredentials
 	}
 
-	token, err := auth.GenerateToken(user.ID)
+	tokens, err := s.tokens.Issue(ctx, user, client)
 	if err != nil {
-		s.log.Error("user service: login failed - token generation error",
+		s.log.ErrorContext(ctx, "user service: login failed - token generation error",
 			slog.Uint64("user_id", uint64(user.ID)),
 			slog.Any("error", err),
 		)
-		return "", err
+		return nil, err
 	}
 
-	s.log.Info("user service: login success",
+	s.log.InfoContext(ctx, "user service: login success",
 		slog.Uint64("user_id", uint64(user.ID)),
 	)
+	metrics.Logins.WithLabelValues("success").Inc()
 
-	return token, nil
+	return tokens, nil
 }
 
-func (s *userService) GetByID(id uint) (*models.User, error) {
-	s.log.Info("user service: get by id started",
+func (s *userService) GetByID(ctx context.Context, id uint) (*models.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.GetByID")
+	defer span.End()
+
+	s.log.InfoContext(ctx, "user service: get by id started",
 		slog.Uint64("user_id", uint64(id)),
 	)
 
-	user, err := s.users.GetByID(id)
+	user, err := s.users.GetByID(ctx, id)
 	if err != nil {
-		s.log.Error("user service: get by id failed",
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUserNotFound
+		}
+		s.log.ErrorContext(ctx, "user service: get by id failed",
 			slog.Uint64("user_id", uint64(id)),
 			slog.Any("error", err),
 		)
 		return nil, err
 	}
 
-	s.log.Info("user service: get by id success",
+	s.log.InfoContext(ctx, "user service: get by id success",
 		slog.Uint64("user_id", uint64(id)),
 	)
 
 	return user, nil
 }
 
+// UpdateProfile applies the fields set in req. A new locale reaches access
+// tokens on the next refresh; until then error messages keep the old one.
+func (s *userService) UpdateProfile(ctx context.Context, id uint, req dto.UpdateProfileRequest) (*models.User, error) {
+	ctx, span := tracing.Start(ctx, "UserService.UpdateProfile")
+	defer span.End()
+
+	if req.Locale != nil {
+		if *req.Locale != "" && !i18n.Supported(*req.Locale) {
+			return nil, ErrUnsupportedLocale
+		}
+		if err := s.users.UpdateLocale(ctx, id, *req.Locale); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, ErrUserNotFound
+			}
+			return nil, err
+		}
+		s.log.InfoContext(ctx, "user service: locale updated",
+			slog.Uint64("user_id", uint64(id)),
+			slog.String("locale", *req.Locale),
+		)
+	}
+
+	return s.GetByID(ctx, id)
+}
+
 func (s *userService) validateUserRegister(req dto.RegisterRequest) error {
+	var fields []apperr.FieldError
 	if req.Name == "" {
-		return errors.New("имя не может быть пустым")
+		fields = append(fields, apperr.NewFieldError("name", "required"))
 	}
 	if req.Email == "" {
-		return errors.New("эмайл не может быть пустым")
+		fields = append(fields, apperr.NewFieldError("email", "required"))
 	}
 	if req.Password == "" {
-		return errors.New("пароль не может быть пустым")
+		fields = append(fields, apperr.NewFieldError("password", "required"))
+	}
+	if len(fields) > 0 {
+		return apperr.Validation(fields...)
+	}
+	if req.Locale != "" && !i18n.Supported(req.Locale) {
+		return ErrUnsupportedLocale
 	}
 	return nil
 }
@@ -0,0 +1,40 @@
+// Package storage keeps attachment contents. Blob hides whether objects live
+// on the local filesystem or in an S3-compatible bucket; metadata stays in
+// the database.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/DjMariarty/messenger/internal/config"
+)
+
+var ErrNotFound = errors.New("storage: object not found")
+
+// Blob stores opaque objects under slash-separated keys.
+type Blob interface {
+	// Put stores exactly size bytes from r under key, replacing any
+	// previous object.
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open returns the object's contents, seekable so they can be served
+	// with range requests; the caller closes the reader.
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	// Ping reports whether the backend is reachable, for readiness checks.
+	Ping(ctx context.Context) error
+}
+
+// New builds the Blob selected by cfg.Driver.
+func New(ctx context.Context, cfg config.StorageConfig) (Blob, error) {
+	switch cfg.Driver {
+	case "local":
+		return NewLocal(cfg.LocalDir)
+	case "s3":
+		return NewS3(ctx, cfg)
+	default:
+		return nil, fmt.Errorf("storage: unknown driver %q", cfg.Driver)
+	}
+}
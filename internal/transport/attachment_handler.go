@@ -0,0 +1,157 @@
+package transport
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/DjMariarty/messenger/internal/config"
+	"github.com/DjMariarty/messenger/internal/dto"
+	"github.com/DjMariarty/messenger/internal/services"
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is room for the form's boundaries and part headers on
+// top of the file itself.
+const multipartOverhead = 1 << 20
+
+type AttachmentHandler struct {
+	service services.AttachmentService
+	cfg     config.AttachmentConfig
+	log     *slog.Logger
+}
+
+func NewAttachmentHandler(service services.AttachmentService, cfg config.AttachmentConfig, log *slog.Logger) *AttachmentHandler {
+	return &AttachmentHandler{service: service, cfg: cfg, log: log}
+}
+
+// POST /attachments (multipart: chat_id, file)
+func (h *AttachmentHandler) Upload(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	// The server-wide read timeout is meant for small JSON bodies.
+	_ = http.NewResponseController(c.Writer).SetReadDeadline(time.Now().Add(h.cfg.TransferTimeout))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.cfg.MaxSize+multipartOverhead)
+
+	var req dto.UploadAttachmentRequest
+	if err := c.ShouldBind(&req); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(c, services.ErrAttachmentTooLarge.WithDetails(map[string]any{"max_size": h.cfg.MaxSize}))
+			return
+		}
+		writeBindError(c, err)
+		return
+	}
+
+	file, err := req.File.Open()
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer file.Close()
+
+	att, err := h.service.Upload(c.Request.Context(), userID, req.ChatID, services.Upload{
+		FileName: req.File.Filename,
+		Size:     req.File.Size,
+		Content:  file,
+	})
+	if err != nil {
+		h.log.WarnContext(c.Request.Context(), "handler: failed to upload attachment",
+			slog.Uint64("chat_id", uint64(req.ChatID)),
+			slog.String("error", err.Error()),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, att)
+}
+
+// GET /attachments/:id
+func (h *AttachmentHandler) Get(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	att, err := h.service.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, att)
+}
+
+// GET /attachments/:id/content
+func (h *AttachmentHandler) Content(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	att, content, err := h.service.Open(c.Request.Context(), userID, id)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer content.Close()
+
+	// Images are shown in place; anything else is downloaded, so an uploaded
+	// HTML page can never run in our origin.
+	disposition := "attachment"
+	if strings.HasPrefix(att.MimeType, "image/") {
+		disposition = "inline"
+	}
+	h.serve(c, content, att.MimeType, att.Checksum, att.CreatedAt,
+		mime.FormatMediaType(disposition, map[string]string{"filename": att.FileName}))
+}
+
+// GET /attachments/:id/thumbnails/:size
+func (h *AttachmentHandler) Thumbnail(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	id, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+	size, ok := uintParam(c, "size")
+	if !ok {
+		return
+	}
+
+	thumb, content, err := h.service.OpenThumbnail(c.Request.Context(), userID, id, int(size))
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	defer content.Close()
+
+	// Thumbnails of one attachment never change, so the key identifies the
+	// content as well as a checksum would.
+	h.serve(c, content, thumb.MimeType, path.Base(thumb.StorageKey), time.Time{}, "inline")
+}
+
+// serve writes stored content with headers that keep browsers from
+// interpreting it as anything but its own type. ServeContent handles range
+// and conditional requests.
+func (h *AttachmentHandler) serve(c *gin.Context, content io.ReadSeeker, mimeType, etag string, modified time.Time, disposition string) {
+	_ = http.NewResponseController(c.Writer).SetWriteDeadline(time.Now().Add(h.cfg.TransferTimeout))
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", mimeType)
+	header.Set("Content-Disposition", disposition)
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	header.Set("Cache-Control", "private, max-age=86400")
+	header.Set("ETag", `"`+etag+`"`)
+
+	http.ServeContent(c.Writer, c.Request, "", modified, content)
+}
@@ -1,8 +1,12 @@
 package transport
 
 import (
+	"errors"
+	"io"
 	"net/http"
+	"strconv"
 
+	"github.com/DjMariarty/messenger/internal/apperr"
 	"github.com/DjMariarty/messenger/internal/dto"
 	"github.com/DjMariarty/messenger/internal/services"
 	"github.com/gin-gonic/gin"
@@ -22,32 +26,181 @@ func (h *ChatHandler) CreateChat(c *gin.Context) {
 
 	var req dto.CreateChatRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		writeBindError(c, err)
 		return
 	}
 
-	chat, err := h.chats.CreateChat(userID, req)
+	chat, err := h.chats.CreateChat(c.Request.Context(), userID, req)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	c.JSON(http.StatusCreated, dto.CreateChatResponse{
-		ChatID:  chat.ID,
-		User1ID: chat.User1ID,
-		User2ID: chat.User2ID,
-	})
+	res := dto.CreateChatResponse{
+		ChatID:    chat.ID,
+		Type:      chat.Type,
+		Title:     chat.Title,
+		MemberIDs: make([]uint, 0, len(chat.Members)),
+	}
+	if chat.User1ID != nil && chat.User2ID != nil {
+		res.User1ID, res.User2ID = *chat.User1ID, *chat.User2ID
+	}
+	for _, m := range chat.Members {
+		res.MemberIDs = append(res.MemberIDs, m.UserID)
+	}
+
+	c.JSON(http.StatusCreated, res)
 }
 
-// GET /chats
+// GET /chats?before=<cursor>&limit=N
 func (h *ChatHandler) GetChats(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
-	list, err := h.chats.GetChats(userID)
+	var query dto.ChatListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	page, err := h.chats.GetChats(c.Request.Context(), userID, query)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// POST /chats/:id/read
+func (h *ChatHandler) MarkRead(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	// The body is optional: without message_id the whole chat is marked read.
+	var req dto.MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBindError(c, err)
+		return
+	}
+
+	receipt, err := h.chats.MarkRead(c.Request.Context(), userID, chatID, req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		writeError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, receipt)
+}
+
+// POST /chats/:id/members
+func (h *ChatHandler) AddMembers(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req dto.AddMembersRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.chats.AddMembers(c.Request.Context(), userID, chatID, req.UserIDs); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /chats/:id/members/:user_id
+func (h *ChatHandler) RemoveMember(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+	memberID, ok := uintParam(c, "user_id")
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, list)
+	if err := h.chats.RemoveMember(c.Request.Context(), userID, chatID, memberID); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /chats/:id/leave
+func (h *ChatHandler) LeaveChat(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.chats.LeaveChat(c.Request.Context(), userID, chatID); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PATCH /chats/:id/members/:user_id
+func (h *ChatHandler) SetMemberRole(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+	memberID, ok := uintParam(c, "user_id")
+	if !ok {
+		return
+	}
+
+	var req dto.SetMemberRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.chats.SetMemberRole(c.Request.Context(), userID, chatID, memberID, req.Role); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// POST /chats/:id/owner
+func (h *ChatHandler) TransferOwnership(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var req dto.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.chats.TransferOwnership(c.Request.Context(), userID, chatID, req.UserID); err != nil {
+		writeError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// uintParam parses a numeric path parameter and answers 400 if it is not one.
+func uintParam(c *gin.Context, name string) (uint, bool) {
+	v, err := strconv.ParseUint(c.Param(name), 10, 64)
+	if err != nil || v == 0 {
+		writeError(c, apperr.Field(name, "id"))
+		return 0, false
+	}
+	return uint(v), true
 }
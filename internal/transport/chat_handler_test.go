@@ -0,0 +1,67 @@
+package transport
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/DjMariarty/messenger/internal/middleware"
+	"github.com/DjMariarty/messenger/internal/repository"
+	"github.com/DjMariarty/messenger/internal/services"
+	"github.com/gin-gonic/gin"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// A request whose deadline passes while its query runs gets 504, and the
+// query is cancelled instead of running to completion.
+func TestGetChatsDeadline(t *testing.T) {
+	const queryTime = 5 * time.Second
+
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sqlDB.Close()
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT c.id AS chat_id")).
+		WillDelayFor(queryTime).
+		WillReturnRows(sqlmock.NewRows([]string{"chat_id"}))
+
+	chats := repository.NewChatRepository(db)
+	handler := NewChatHandler(services.NewChatService(db, chats, nil, nil, services.NewChatAccess(chats), nil))
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.Errors(), middleware.Timeout(50*time.Millisecond))
+	router.GET("/chats", func(c *gin.Context) { c.Set("user_id", uint(1)) }, handler.GetChats)
+
+	start := time.Now()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/chats", nil))
+	elapsed := time.Since(start)
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d; body %s", rec.Code, http.StatusGatewayTimeout, rec.Body)
+	}
+	var body struct {
+		Code string `json:"code"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Code != "timeout" {
+		t.Fatalf("body = %s, want code timeout", rec.Body)
+	}
+	if elapsed >= queryTime {
+		t.Fatalf("request took %v: the query was not cancelled", elapsed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,28 @@
+package transport
+
+import (
+	"github.com/DjMariarty/messenger/internal/apperr"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+)
+
+// writeError hands err to middleware.Errors, which renders it once the
+// handler returns.
+func writeError(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+}
+
+// writeBindError reports a failed ShouldBind* call as a validation error.
+func writeBindError(c *gin.Context, err error) {
+	writeError(c, apperr.FromBinding(err))
+}
+
+// UseJSONFieldNames makes binding validation errors name fields by their
+// json or form tag instead of the Go field name.
+func UseJSONFieldNames() {
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(apperr.JSONFieldName)
+	}
+}
@@ -0,0 +1,31 @@
+package transport
+
+import (
+	"net/http"
+
+	"github.com/DjMariarty/messenger/internal/health"
+	"github.com/gin-gonic/gin"
+)
+
+type HealthHandler struct {
+	registry *health.Registry
+}
+
+func NewHealthHandler(registry *health.Registry) *HealthHandler {
+	return &HealthHandler{registry: registry}
+}
+
+// GET /healthz
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, h.registry.Live())
+}
+
+// GET /readyz
+func (h *HealthHandler) Ready(c *gin.Context) {
+	report := h.registry.Ready(c.Request.Context())
+	if !report.Healthy() {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
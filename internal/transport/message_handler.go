@@ -1,9 +1,12 @@
 package transport
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
-	"strconv"
+	"net/url"
 
 	"github.com/DjMariarty/messenger/internal/dto"
 	"github.com/DjMariarty/messenger/internal/services"
@@ -20,59 +23,232 @@ func NewMessageHandler(service services.MessageService, log *slog.Logger) *Messa
 }
 
 func (h *MessageHandler) CreateMessage(c *gin.Context) {
-	// логи на входе
+	userID := c.MustGet("user_id").(uint)
 
 	var req dto.CreateMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Warn("handler: invalid JSON in CreateMessage", slog.String("error", err.Error()))
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		h.log.WarnContext(c.Request.Context(), "handler: invalid JSON in CreateMessage", slog.String("error", err.Error()))
+		writeBindError(c, err)
 		return
 	}
 
-	msg, err := h.service.CreateMessage(req)
+	msg, err := h.service.CreateMessage(c.Request.Context(), userID, req)
 	if err != nil {
-		h.log.Error("handler: failed to create message", slog.String("error", err.Error()))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		h.log.ErrorContext(c.Request.Context(), "handler: failed to create message", slog.String("error", err.Error()))
+		writeError(c, err)
 		return
 	}
 
-	h.log.Info("handler: creating message",
+	h.log.InfoContext(c.Request.Context(), "handler: creating message",
 		slog.Uint64("chat_id", uint64(req.ChatID)),
-		slog.Uint64("sender_id", uint64(req.SenderID)),
+		slog.Uint64("sender_id", uint64(userID)),
 	)
 	c.JSON(http.StatusCreated, msg)
 }
 
-func (h *MessageHandler) GetMessages(c *gin.Context) {
-	userIDParam := c.Param("userID")
-	if userIDParam == "" {
-		h.log.Warn("handler: missing chat_id in path")
-		c.JSON(http.StatusBadRequest, gin.H{"error": "chatID id required"})
+// GET /chats/:id/messages?before=<cursor>&after=<cursor>&limit=N
+func (h *MessageHandler) ListChatMessages(c *gin.Context) {
+	page, ok := h.listChatMessages(c)
+	if !ok {
 		return
 	}
+	c.JSON(http.StatusOK, page)
+}
+
+// GET /messages/:id, where :id is a chat id.
+//
+// Deprecated: the original history endpoint, kept for old clients. It
+// returns the first page of GET /chats/:id/messages as a bare array, newest
+// first, and links the successor in the response headers.
+func (h *MessageHandler) ListChatMessagesLegacy(c *gin.Context) {
+	page, ok := h.listChatMessages(c)
+	if !ok {
+		return
+	}
+	successor := "/chats/" + c.Param("id") + "/messages"
+	links := fmt.Sprintf("<%s>; rel=\"successor-version\"", successor)
+	if page.NextCursor != "" {
+		links += fmt.Sprintf(", <%s?before=%s>; rel=\"next\"", successor, url.QueryEscape(page.NextCursor))
+	}
+	c.Header("Deprecation", "true")
+	c.Header("Link", links)
+	c.JSON(http.StatusOK, page.Messages)
+}
+
+func (h *MessageHandler) listChatMessages(c *gin.Context) (*dto.MessagePage, bool) {
+	userID := c.MustGet("user_id").(uint)
+
+	chatID, ok := uintParam(c, "id")
+	if !ok {
+		h.log.WarnContext(c.Request.Context(), "handler: invalid chat_id format", slog.String("chat_id", c.Param("id")))
+		return nil, false
+	}
+
+	var query dto.MessageHistoryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		h.log.WarnContext(c.Request.Context(), "handler: invalid history query", slog.String("error", err.Error()))
+		writeBindError(c, err)
+		return nil, false
+	}
 
-	userID, err := strconv.ParseUint(userIDParam, 10, 64)
+	page, err := h.service.ListMessages(c.Request.Context(), userID, chatID, query)
 	if err != nil {
-		h.log.Warn("handler: invalid user_id format", slog.String("user_id", userIDParam))
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chatID"})
+		h.log.ErrorContext(c.Request.Context(), "handler: failed to get messages",
+			slog.Uint64("chat_id", uint64(chatID)),
+			slog.String("error", err.Error()),
+		)
+		writeError(c, err)
+		return nil, false
+	}
+
+	h.log.InfoContext(c.Request.Context(), "handler: messages fetched successfully",
+		slog.Uint64("chat_id", uint64(chatID)),
+		slog.Int("count", len(page.Messages)),
+	)
+	return page, true
+}
+
+// PATCH /messages/:id
+func (h *MessageHandler) EditMessage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	messageID, ok := uintParam(c, "id")
+	if !ok {
 		return
 	}
 
-	messages, err := h.service.GetMessagesByChatID(uint(userID))
+	var req dto.EditMessageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.WarnContext(c.Request.Context(), "handler: invalid JSON in EditMessage", slog.String("error", err.Error()))
+		writeBindError(c, err)
+		return
+	}
+
+	msg, err := h.service.EditMessage(c.Request.Context(), userID, messageID, req)
 	if err != nil {
-		h.log.Error("handler: failed to get messages",
-			slog.Uint64("user_id", userID),
+		h.log.WarnContext(c.Request.Context(), "handler: failed to edit message",
+			slog.Uint64("message_id", uint64(messageID)),
 			slog.String("error", err.Error()),
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	h.log.Info("handler: messages fetched successfully",
-		slog.Uint64("user_id", userID),
-		slog.Int("count", len(messages)),
-	)
+	c.JSON(http.StatusOK, msg)
+}
+
+// DELETE /messages/:id?scope=me|everyone
+func (h *MessageHandler) DeleteMessage(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	messageID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var query dto.DeleteMessageQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	if err := h.service.DeleteMessage(c.Request.Context(), userID, messageID, query.Scope); err != nil {
+		h.log.WarnContext(c.Request.Context(), "handler: failed to delete message",
+			slog.Uint64("message_id", uint64(messageID)),
+			slog.String("error", err.Error()),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
 
-	c.JSON(http.StatusOK, messages)
+// GET /messages/:id/edits
+func (h *MessageHandler) ListEdits(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	messageID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	edits, err := h.service.ListEdits(c.Request.Context(), userID, messageID)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, edits)
+}
+
+// GET /messages/:id/thread?before=<cursor>&after=<cursor>&limit=N
+func (h *MessageHandler) ListThread(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	rootID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	var query dto.MessageHistoryQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	page, err := h.service.ListThread(c.Request.Context(), userID, rootID, query)
+	if err != nil {
+		h.log.WarnContext(c.Request.Context(), "handler: failed to get thread",
+			slog.Uint64("message_id", uint64(rootID)),
+			slog.String("error", err.Error()),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// POST /messages/:id/thread/read
+func (h *MessageHandler) MarkThreadRead(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+	rootID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	// The body is optional: without message_id the whole thread is marked read.
+	var req dto.MarkReadRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		writeBindError(c, err)
+		return
+	}
+
+	receipt, err := h.service.MarkThreadRead(c.Request.Context(), userID, rootID, req)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, receipt)
+}
+
+// GET /search/messages?q=...&chat_id=N&sender_id=N&from=<time>&before=<time>&cursor=<cursor>&limit=N
+func (h *MessageHandler) SearchMessages(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var query dto.MessageSearchQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	page, err := h.service.SearchMessages(c.Request.Context(), userID, query)
+	if err != nil {
+		h.log.WarnContext(c.Request.Context(), "handler: message search failed",
+			slog.Uint64("chat_id", uint64(query.ChatID)),
+			slog.String("error", err.Error()),
+		)
+		writeError(c, err)
+		return
+	}
 
+	c.JSON(http.StatusOK, page)
 }
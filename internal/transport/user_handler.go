@@ -5,141 +5,277 @@ import (
 	"log/slog"
 	"net/http"
 
+	"github.com/DjMariarty/messenger/internal/auth"
 	"github.com/DjMariarty/messenger/internal/dto"
 	"github.com/DjMariarty/messenger/internal/services"
 	"github.com/gin-gonic/gin"
-	"gorm.io/gorm"
 )
 
 type UserHandler struct {
-	users services.UserService
-	log   *slog.Logger
+	users  services.UserService
+	tokens services.TokenService
+	log    *slog.Logger
 }
 
-func NewUserHandler(users services.UserService, log *slog.Logger) *UserHandler {
+func NewUserHandler(users services.UserService, tokens services.TokenService, log *slog.Logger) *UserHandler {
 	return &UserHandler{
-		users: users,
-		log:   log,
+		users:  users,
+		tokens: tokens,
+		log:    log,
 	}
 }
 
 func (h *UserHandler) Register(c *gin.Context) {
 	var req dto.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Warn("user handler: register bind json failed",
+		h.log.WarnContext(c.Request.Context(), "user handler: register bind json failed",
 			slog.Any("error", err),
 		)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		writeBindError(c, err)
 		return
 	}
 
-	h.log.Info("user handler: register request received",
+	h.log.InfoContext(c.Request.Context(), "user handler: register request received",
 		slog.String("email", req.Email),
 	)
 
-	user, err := h.users.RegisterUser(req)
+	user, err := h.users.RegisterUser(c.Request.Context(), req)
 	if err != nil {
-
-		if err.Error() == "пользователь с таким email уже существует" {
-			h.log.Warn("user handler: register conflict - email exists",
+		if errors.Is(err, services.ErrEmailTaken) {
+			h.log.WarnContext(c.Request.Context(), "user handler: register conflict - email exists",
 				slog.String("email", req.Email),
 			)
-			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
-			return
-		}
-
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			h.log.Warn("user handler: register failed - record not found",
+		} else {
+			h.log.ErrorContext(c.Request.Context(), "user handler: register failed",
 				slog.String("email", req.Email),
+				slog.Any("error", err),
 			)
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
 		}
-
-		h.log.Error("user handler: register failed",
-			slog.String("email", req.Email),
-			slog.Any("error", err),
-		)
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		writeError(c, err)
 		return
 	}
 
-	h.log.Info("user handler: register success",
+	h.log.InfoContext(c.Request.Context(), "user handler: register success",
 		slog.Uint64("user_id", uint64(user.ID)),
 		slog.String("email", user.Email),
 	)
 
 	c.JSON(http.StatusCreated, dto.UserResponse{
-		ID:    user.ID,
-		Name:  user.Name,
-		Email: user.Email,
+		ID:     user.ID,
+		Name:   user.Name,
+		Email:  user.Email,
+		Locale: user.Locale,
 	})
 }
 
 func (h *UserHandler) Login(c *gin.Context) {
 	var req dto.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		h.log.Warn("user handler: login bind json failed",
+		h.log.WarnContext(c.Request.Context(), "user handler: login bind json failed",
 			slog.Any("error", err),
 		)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request body"})
+		writeBindError(c, err)
 		return
 	}
 
-	h.log.Info("user handler: login request received",
+	h.log.InfoContext(c.Request.Context(), "user handler: login request received",
 		slog.String("email", req.Email),
 	)
 
-	token, err := h.users.LoginUser(req)
+	tokens, err := h.users.LoginUser(c.Request.Context(), req, clientInfo(c))
 	if err != nil {
 
-		h.log.Warn("user handler: login failed",
+		h.log.WarnContext(c.Request.Context(), "user handler: login failed",
 			slog.String("email", req.Email),
 			slog.Any("error", err),
 		)
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid credentials"})
+		writeError(c, err)
 		return
 	}
 
-	h.log.Info("user handler: login success",
+	h.log.InfoContext(c.Request.Context(), "user handler: login success",
 		slog.String("email", req.Email),
 	)
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	c.JSON(http.StatusOK, tokens)
+}
+
+// POST /auth/refresh
+func (h *UserHandler) Refresh(c *gin.Context) {
+	var req dto.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		h.log.WarnContext(c.Request.Context(), "user handler: refresh bind json failed",
+			slog.Any("error", err),
+		)
+		writeBindError(c, err)
+		return
+	}
+
+	tokens, err := h.tokens.Refresh(c.Request.Context(), req.RefreshToken, clientInfo(c))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidRefreshToken) || errors.Is(err, services.ErrRefreshTokenReused) {
+			h.log.WarnContext(c.Request.Context(), "user handler: refresh rejected",
+				slog.Any("error", err),
+			)
+			writeError(c, err)
+			return
+		}
+
+		h.log.ErrorContext(c.Request.Context(), "user handler: refresh failed",
+			slog.Any("error", err),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// POST /auth/logout
+func (h *UserHandler) Logout(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	if err := h.tokens.Logout(c.Request.Context(), claims); err != nil {
+		h.log.ErrorContext(c.Request.Context(), "user handler: logout failed",
+			slog.Uint64("user_id", uint64(claims.UserID)),
+			slog.Any("error", err),
+		)
+		writeError(c, err)
+		return
+	}
+
+	h.log.InfoContext(c.Request.Context(), "user handler: logout success",
+		slog.Uint64("user_id", uint64(claims.UserID)),
+	)
+	c.Status(http.StatusNoContent)
+}
+
+// GET /auth/sessions
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	sessions, err := h.tokens.ListSessions(c.Request.Context(), claims)
+	if err != nil {
+		h.log.ErrorContext(c.Request.Context(), "user handler: list sessions failed",
+			slog.Uint64("user_id", uint64(claims.UserID)),
+			slog.Any("error", err),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// DELETE /auth/sessions/:id
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+	sessionID, ok := uintParam(c, "id")
+	if !ok {
+		return
+	}
+
+	if err := h.tokens.RevokeSession(c.Request.Context(), claims, sessionID); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			writeError(c, err)
+			return
+		}
+
+		h.log.ErrorContext(c.Request.Context(), "user handler: revoke session failed",
+			slog.Uint64("user_id", uint64(claims.UserID)),
+			slog.Any("error", err),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// DELETE /auth/sessions logs out every session except the current one.
+func (h *UserHandler) RevokeOtherSessions(c *gin.Context) {
+	claims := c.MustGet("claims").(*auth.Claims)
+
+	if err := h.tokens.RevokeOtherSessions(c.Request.Context(), claims); err != nil {
+		h.log.ErrorContext(c.Request.Context(), "user handler: revoke other sessions failed",
+			slog.Uint64("user_id", uint64(claims.UserID)),
+			slog.Any("error", err),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
 func (h *UserHandler) Me(c *gin.Context) {
 	userID := c.MustGet("user_id").(uint)
 
-	h.log.Info("user handler: me request received",
+	h.log.InfoContext(c.Request.Context(), "user handler: me request received",
 		slog.Uint64("user_id", uint64(userID)),
 	)
 
-	user, err := h.users.GetByID(userID)
+	user, err := h.users.GetByID(c.Request.Context(), userID)
 	if err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			h.log.Warn("user handler: me user not found",
+		if errors.Is(err, services.ErrUserNotFound) {
+			h.log.WarnContext(c.Request.Context(), "user handler: me user not found",
 				slog.Uint64("user_id", uint64(userID)),
 			)
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			writeError(c, err)
 			return
 		}
 
-		h.log.Error("user handler: me failed",
+		h.log.ErrorContext(c.Request.Context(), "user handler: me failed",
 			slog.Uint64("user_id", uint64(userID)),
 			slog.Any("error", err),
 		)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "internal error"})
+		writeError(c, err)
 		return
 	}
 
-	h.log.Info("user handler: me success",
+	h.log.InfoContext(c.Request.Context(), "user handler: me success",
 		slog.Uint64("user_id", uint64(userID)),
 	)
 
 	c.JSON(http.StatusOK, gin.H{
-		"id":    user.ID,
-		"name":  user.Name,
-		"email": user.Email,
+		"id":     user.ID,
+		"name":   user.Name,
+		"email":  user.Email,
+		"locale": user.Locale,
 	})
 }
+
+// PATCH /auth/me
+func (h *UserHandler) UpdateMe(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	var req dto.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		writeBindError(c, err)
+		return
+	}
+
+	user, err := h.users.UpdateProfile(c.Request.Context(), userID, req)
+	if err != nil {
+		h.log.WarnContext(c.Request.Context(), "user handler: update profile failed",
+			slog.Uint64("user_id", uint64(userID)),
+			slog.Any("error", err),
+		)
+		writeError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dto.UserResponse{
+		ID:     user.ID,
+		Name:   user.Name,
+		Email:  user.Email,
+		Locale: user.Locale,
+	})
+}
+
+func clientInfo(c *gin.Context) dto.ClientInfo {
+	return dto.ClientInfo{
+		UserAgent: c.Request.UserAgent(),
+		IP:        c.ClientIP(),
+	}
+}
@@ -0,0 +1,46 @@
+package transport
+
+import (
+	"log/slog"
+
+	"github.com/DjMariarty/messenger/internal/realtime"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type WSHandler struct {
+	hub      *realtime.Hub
+	upgrader websocket.Upgrader
+	log      *slog.Logger
+}
+
+func NewWSHandler(hub *realtime.Hub, log *slog.Logger) *WSHandler {
+	return &WSHandler{
+		hub: hub,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+		},
+		log: log,
+	}
+}
+
+// GET /ws
+func (h *WSHandler) Connect(c *gin.Context) {
+	userID := c.MustGet("user_id").(uint)
+
+	conn, err := h.upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error to the client.
+		h.log.WarnContext(c.Request.Context(), "ws handler: upgrade failed",
+			slog.Uint64("user_id", uint64(userID)),
+			slog.Any("error", err),
+		)
+		return
+	}
+
+	h.log.InfoContext(c.Request.Context(), "ws handler: client connected",
+		slog.Uint64("user_id", uint64(userID)),
+	)
+	h.hub.Serve(conn, userID)
+}